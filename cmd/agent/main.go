@@ -1,32 +1,103 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/yokitheyo/guardian-metrics/internal/agent"
 	"github.com/yokitheyo/guardian-metrics/internal/agent/collector"
 	"github.com/yokitheyo/guardian-metrics/internal/agent/sender"
+	"github.com/yokitheyo/guardian-metrics/internal/certs"
 	"github.com/yokitheyo/guardian-metrics/internal/config"
+	"github.com/yokitheyo/guardian-metrics/internal/encryption"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
 )
 
 func main() {
-	cfg := config.LoadAgentConfig()
+	cfg, err := config.LoadAgentConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
 
-	if cfg.Address == "" {
-		log.Fatal("address is not set")
+	collectors := []agent.MetricsCollector{
+		collector.NewRuntimeCollector(),
+		collector.NewSystemCollector(),
+	}
+	snd, err := newSender(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if closer, ok := snd.(io.Closer); ok {
+		defer closer.Close()
 	}
 
-	coll := collector.NewRuntimeCollector()
-	snd := sender.NewHTTPSender("http://" + cfg.Address)
+	filter, err := agent.NewFilter(cfg.AllowMetrics, cfg.DenyMetrics, cfg.RenameMetrics)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	a := agent.NewAgent(
-		coll,
+		collectors,
 		snd,
 		cfg.PollInterval,
 		cfg.ReportInterval,
+		cfg.RateLimit,
 		"http://"+cfg.Address,
 	)
 
+	a.SetFilter(filter)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	log.Println("Starting agent...")
-	a.Run()
+	a.Run(ctx)
+	log.Println("Agent stopped")
+}
+
+// newSender reports over gRPC when a gRPC address is configured and over
+// HTTP otherwise, with TLS when any TLS option is set.
+func newSender(cfg *config.AgentConfig) (agent.MetricsSender, error) {
+	var tlsConfig *tls.Config
+	if cfg.TLSCA != "" || cfg.TLSCert != "" || cfg.TLSKey != "" {
+		var err error
+		if tlsConfig, err = certs.ClientConfig(cfg.TLSCA, cfg.TLSCert, cfg.TLSKey); err != nil {
+			return nil, err
+		}
+	}
+	id := identity.Identity{Source: cfg.AgentID, Tenant: cfg.Tenant}
+
+	if cfg.GRPCAddress != "" {
+		if cfg.CryptoKey != "" {
+			return nil, errors.New("request encryption is only supported over HTTP")
+		}
+		return sender.NewGRPCSender(cfg.GRPCAddress, cfg.Key, cfg.Labels, id, tlsConfig)
+	}
+
+	scheme := "http://"
+	if tlsConfig != nil {
+		scheme = "https://"
+	}
+	s := sender.NewHTTPSender(scheme+cfg.Address, cfg.Key, cfg.Labels, id)
+	if tlsConfig != nil {
+		s.SetTLSConfig(tlsConfig)
+	}
+	if cfg.CryptoKey != "" {
+		key, err := encryption.LoadPublicKey(cfg.CryptoKey)
+		if err != nil {
+			return nil, err
+		}
+		s.SetPublicKey(key)
+	}
+	return s, nil
 }
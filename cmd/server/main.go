@@ -1,7 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/yokitheyo/guardian-metrics/internal/config"
 	"github.com/yokitheyo/guardian-metrics/internal/server"
@@ -10,8 +17,13 @@ import (
 )
 
 func main() {
-	cfg := config.LoadServerConfig()
-	storage := storage.NewMemStorage()
+	cfg, err := config.LoadServerConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
 
 	logger, err := zap.NewProduction()
 	if err != nil {
@@ -19,7 +31,45 @@ func main() {
 	}
 	defer logger.Sync()
 
-	if err := server.RunServer(storage, cfg.Address, logger); err != nil {
-		log.Fatalf("server failed: %v", err)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	store, err := newStorage(ctx, cfg)
+	if err != nil {
+		logger.Fatal("failed to initialize storage", zap.Error(err))
+	}
+
+	if err := server.RunServer(ctx, store, cfg, logger); err != nil {
+		logger.Error("server failed", zap.Error(err))
+	}
+
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			logger.Error("failed to close storage", zap.Error(err))
+		}
+	}
+	logger.Info("server stopped")
+}
+
+// newStorage picks the backend by configuration: a database DSN wins over a
+// file path, and with neither metrics live only in memory. Only the latter
+// two keep history.
+func newStorage(ctx context.Context, cfg *config.ServerConfig) (storage.Storage, error) {
+	retention := storage.Retention{MaxPoints: cfg.HistoryPoints, MaxAge: cfg.HistoryAge}
+
+	switch {
+	case cfg.DatabaseDSN != "":
+		return storage.NewSQLStorage(ctx, cfg.DatabaseDSN)
+	case cfg.FileStoragePath != "":
+		s, err := storage.NewFileStorage(cfg.FileStoragePath, cfg.StoreInterval, cfg.Restore)
+		if err != nil {
+			return nil, err
+		}
+		s.SetRetention(retention)
+		return s, nil
+	default:
+		s := storage.NewMemStorage()
+		s.SetRetention(retention)
+		return s, nil
 	}
 }
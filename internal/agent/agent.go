@@ -1,52 +1,250 @@
 package agent
 
 import (
+	"context"
 	"log"
+	"sync"
 	"time"
+
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
 )
 
+// finalReportTimeout bounds the report sent while the agent is stopping.
+const finalReportTimeout = 5 * time.Second
+
 type Agent struct {
-	collector      MetricsCollector
+	collectors     []MetricsCollector
 	sender         MetricsSender
 	pollInterval   time.Duration
 	reportInterval time.Duration
+	rateLimit      int
 	serverAddress  string
+
+	mu     sync.Mutex
+	filter Filter
+	gauges map[seriesKey]storage.Metric
+	// pending holds the counter increases and histogram observations not
+	// yet acknowledged by the server, including those in reports still
+	// being sent.
+	pending map[seriesKey]storage.Metric
+}
+
+// seriesKey identifies a reported series.
+type seriesKey struct {
+	mtype  storage.MetricType
+	id     string
+	labels string
+}
+
+func keyOf(m storage.Metric) seriesKey {
+	return seriesKey{mtype: m.MType, id: m.ID, labels: m.Labels.Key()}
 }
 
-func NewAgent(collector MetricsCollector, sender MetricsSender, pollInterval, reportInterval time.Duration, serverAddress string) *Agent {
+// NewAgent creates an agent that merges the output of all collectors and
+// sends reports through at most rateLimit concurrent requests. A rateLimit
+// below one is treated as one.
+func NewAgent(collectors []MetricsCollector, sender MetricsSender, pollInterval, reportInterval time.Duration, rateLimit int, serverAddress string) *Agent {
+	if rateLimit < 1 {
+		rateLimit = 1
+	}
 	return &Agent{
-		collector:      collector,
+		collectors:     collectors,
 		sender:         sender,
 		pollInterval:   pollInterval,
 		reportInterval: reportInterval,
+		rateLimit:      rateLimit,
 		serverAddress:  serverAddress,
+		gauges:         make(map[seriesKey]storage.Metric),
+		pending:        make(map[seriesKey]storage.Metric),
 	}
 }
 
-func (a *Agent) Run() {
-	pollTicker := time.NewTicker(a.pollInterval)
-	reportTicker := time.NewTicker(a.reportInterval)
-	defer pollTicker.Stop()
-	defer reportTicker.Stop()
+// SetFilter limits and renames the metrics reported from now on.
+func (a *Agent) SetFilter(f Filter) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.filter = f
+}
+
+// Run polls and reports metrics until ctx is cancelled. Every collector is
+// polled in its own goroutine, and polling and sending are decoupled by a
+// channel feeding a pool of rateLimit workers, so neither a slow collector
+// nor a slow server delays the rest. The latest metrics are sent in one
+// final report before Run returns.
+func (a *Agent) Run(ctx context.Context) {
+	reports := make(chan []storage.Metric, a.rateLimit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < a.rateLimit; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.sendWorker(ctx, reports)
+		}()
+	}
+
+	for _, c := range a.collectors {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.pollLoop(ctx, func() { a.merge(c.CollectMetrics()) })
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		a.pollLoop(ctx, a.countPoll)
+	}()
 
-	metrics := make(map[string]float64)
-	pollCount := int64(0)
+	a.reportLoop(ctx, reports)
+	close(reports)
+	wg.Wait()
+
+	// ctx is already cancelled, so the final report gets its own deadline
+	// instead of being aborted before it starts.
+	finalCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), finalReportTimeout)
+	defer cancel()
+	report := a.snapshot()
+	if err := a.sender.SendMetrics(finalCtx, report); err != nil {
+		a.requeue(report)
+		log.Printf("failed to send final metrics: %v", err)
+	}
+}
+
+// pollLoop calls poll on every poll tick until ctx is cancelled.
+func (a *Agent) pollLoop(ctx context.Context, poll func()) {
+	ticker := time.NewTicker(a.pollInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
-		case <-pollTicker.C:
-			runtimeMetrics := a.collector.CollectMetrics()
-			for k, v := range runtimeMetrics {
-				metrics[k] = v
-			}
-			pollCount++
-			metrics["PollCount"] = float64(pollCount)
-			metrics["RandomValue"] = float64(time.Now().UnixNano())
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			poll()
+		}
+	}
+}
+
+// merge records collected metrics that pass the filter. Gauges replace the
+// previous value, while counters and histograms add to what is pending.
+func (a *Agent) merge(collected []storage.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, m := range collected {
+		m, ok := a.filter.Apply(m)
+		if !ok {
+			continue
+		}
+		if err := m.Validate(); err != nil {
+			log.Printf("skipping metric %q: %v", m.ID, err)
+			continue
+		}
+		if m.MType == storage.Gauge {
+			a.gauges[keyOf(m)] = m
+			continue
+		}
+		a.addPending(m)
+	}
+}
+
+// addPending adds a counter or histogram update to the pending one of the
+// same series. Histograms whose buckets changed replace the pending
+// observations.
+func (a *Agent) addPending(m storage.Metric) {
+	key := keyOf(m)
+	prev, ok := a.pending[key]
+	switch m.MType {
+	case storage.Counter:
+		delta := *m.Delta
+		if ok {
+			delta += *prev.Delta
+		}
+		m.Delta = &delta
+	case storage.Histogram:
+		h := m.Histogram.Clone()
+		if ok && h.Merge(prev.Histogram) != nil {
+			log.Printf("histogram %q buckets changed, dropping pending observations", m.ID)
+		}
+		m.Histogram = h
+	}
+	a.pending[key] = m
+}
+
+// countPoll reports the metrics the agent produces itself rather than
+// reading them from a collector.
+func (a *Agent) countPoll() {
+	a.merge([]storage.Metric{
+		storage.NewCounter("PollCount", 1),
+		storage.NewGauge("RandomValue", float64(time.Now().UnixNano())),
+	})
+}
+
+// snapshot returns the metrics to report, safe to hand over to a send
+// worker. The pending updates move into the report, so a report that is
+// not sent must be requeued.
+func (a *Agent) snapshot() []storage.Metric {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result := make([]storage.Metric, 0, len(a.gauges)+len(a.pending))
+	for _, m := range a.gauges {
+		result = append(result, m)
+	}
+	for _, m := range a.pending {
+		result = append(result, m)
+	}
+	a.pending = make(map[seriesKey]storage.Metric)
+	return result
+}
+
+// requeue returns the counter increases and histogram observations of a
+// report that was not sent, so the next report carries them.
+func (a *Agent) requeue(report []storage.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, m := range report {
+		if m.MType != storage.Gauge {
+			a.addPending(m)
+		}
+	}
+}
+
+// reportLoop queues a snapshot for sending on every report tick. When all
+// workers are busy it blocks, and ticks missed meanwhile are dropped.
+func (a *Agent) reportLoop(ctx context.Context, reports chan<- []storage.Metric) {
+	ticker := time.NewTicker(a.reportInterval)
+	defer ticker.Stop()
 
-		case <-reportTicker.C:
-			if err := a.sender.SendMetrics(metrics); err != nil {
-				log.Printf("failed to send metrics: %v", err)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report := a.snapshot()
+			select {
+			case reports <- report:
+			case <-ctx.Done():
+				a.requeue(report)
+				return
 			}
 		}
 	}
 }
+
+func (a *Agent) sendWorker(ctx context.Context, reports <-chan []storage.Metric) {
+	for report := range reports {
+		if ctx.Err() != nil {
+			a.requeue(report)
+			continue
+		}
+		if err := a.sender.SendMetrics(ctx, report); err != nil {
+			a.requeue(report)
+			log.Printf("failed to send metrics: %v", err)
+		}
+	}
+}
@@ -1,58 +1,301 @@
 package agent
 
 import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
 )
 
 type MockCollector struct {
-	metrics map[string]float64
+	metrics []storage.Metric
+	calls   atomic.Int32
 }
 
-func (m *MockCollector) CollectMetrics() map[string]float64 {
+func (m *MockCollector) CollectMetrics() []storage.Metric {
+	m.calls.Add(1)
 	return m.metrics
 }
 
 type MockSender struct {
+	mu          sync.Mutex
 	sentMetrics []map[string]float64
 }
 
-func (m *MockSender) SendMetrics(metrics map[string]float64) error {
-	m.sentMetrics = append(m.sentMetrics, metrics)
+func (m *MockSender) SendMetrics(ctx context.Context, metrics []storage.Metric) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sentMetrics = append(m.sentMetrics, values(metrics))
 	return nil
 }
 
+// values returns gauge values and counter deltas by name.
+func values(metrics []storage.Metric) map[string]float64 {
+	result := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		switch m.MType {
+		case storage.Gauge:
+			result[m.ID] = *m.Value
+		case storage.Counter:
+			result[m.ID] = float64(*m.Delta)
+		}
+	}
+	return result
+}
+
 func TestAgent(t *testing.T) {
 	collector := &MockCollector{
-		metrics: map[string]float64{
-			"TestMetric": 42.0,
-		},
+		metrics: []storage.Metric{storage.NewGauge("TestMetric", 42)},
 	}
 	sender := &MockSender{}
 
 	a := NewAgent(
-		collector,
+		[]MetricsCollector{collector},
 		sender,
 		100*time.Millisecond,
 		200*time.Millisecond,
+		1,
 		"http://localhost:8080",
 	)
 
-	go a.Run()
-
-	time.Sleep(300 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	a.Run(ctx)
 
 	require.NotEmpty(t, sender.sentMetrics, "No metrics were sent")
 
+	var polls float64
+	for _, m := range sender.sentMetrics {
+		polls += m["PollCount"]
+	}
+	assert.Greater(t, polls, float64(0), "PollCount should be greater than 0")
+
 	lastMetrics := sender.sentMetrics[len(sender.sentMetrics)-1]
 	assert.Contains(t, lastMetrics, "TestMetric", "TestMetric should be present in metrics")
-	assert.Contains(t, lastMetrics, "PollCount", "PollCount should be present in metrics")
 	assert.Contains(t, lastMetrics, "RandomValue", "RandomValue should be present in metrics")
 
 	assert.Equal(t, 42.0, lastMetrics["TestMetric"], "TestMetric value should be 42.0")
-	assert.Greater(t, lastMetrics["PollCount"], float64(0), "PollCount should be greater than 0")
 	assert.Greater(t, lastMetrics["RandomValue"], float64(0), "RandomValue should be greater than 0")
 }
+
+func TestAgent_FinalReportOnStop(t *testing.T) {
+	collector := &MockCollector{
+		metrics: []storage.Metric{storage.NewGauge("TestMetric", 42)},
+	}
+	sender := &MockSender{}
+
+	a := NewAgent(
+		[]MetricsCollector{collector},
+		sender,
+		10*time.Millisecond,
+		time.Hour,
+		1,
+		"http://localhost:8080",
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		a.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return after context cancellation")
+	}
+
+	require.Len(t, sender.sentMetrics, 1, "exactly one final report should be sent")
+	assert.Equal(t, 42.0, sender.sentMetrics[0]["TestMetric"])
+}
+
+// slowSender takes a while for every send and records the highest number of
+// sends in flight at once.
+type slowSender struct {
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+func (s *slowSender) SendMetrics(ctx context.Context, metrics []storage.Metric) error {
+	n := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		cur := s.maxInFlight.Load()
+		if n <= cur || s.maxInFlight.CompareAndSwap(cur, n) {
+			break
+		}
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(50 * time.Millisecond):
+		return nil
+	}
+}
+
+func TestAgent_RateLimitAndDecoupledPolling(t *testing.T) {
+	collector := &MockCollector{metrics: []storage.Metric{storage.NewGauge("TestMetric", 1)}}
+	sender := &slowSender{}
+
+	a := NewAgent(
+		[]MetricsCollector{collector},
+		sender,
+		10*time.Millisecond,
+		10*time.Millisecond,
+		2,
+		"http://localhost:8080",
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	a.Run(ctx)
+
+	assert.Equal(t, int32(2), sender.maxInFlight.Load(), "concurrent sends should be capped by the rate limit")
+	assert.Greater(t, collector.calls.Load(), int32(10), "polling should continue while sends are stuck")
+}
+
+func TestAgent_MultipleCollectors(t *testing.T) {
+	runtimeCollector := &MockCollector{metrics: []storage.Metric{storage.NewGauge("Alloc", 1)}}
+	systemCollector := &MockCollector{metrics: []storage.Metric{storage.NewGauge("TotalMemory", 2)}}
+	sender := &MockSender{}
+
+	a := NewAgent(
+		[]MetricsCollector{runtimeCollector, systemCollector},
+		sender,
+		10*time.Millisecond,
+		time.Hour,
+		1,
+		"http://localhost:8080",
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	a.Run(ctx)
+
+	require.NotEmpty(t, sender.sentMetrics)
+	last := sender.sentMetrics[len(sender.sentMetrics)-1]
+	assert.Equal(t, 1.0, last["Alloc"])
+	assert.Equal(t, 2.0, last["TotalMemory"])
+	assert.Contains(t, last, "PollCount")
+}
+
+func TestAgent_PollCountDelta(t *testing.T) {
+	a := NewAgent(nil, &MockSender{}, time.Second, time.Second, 1, "http://localhost:8080")
+
+	for i := 0; i < 3; i++ {
+		a.countPoll()
+	}
+	failed := a.snapshot()
+	assert.Equal(t, 3.0, values(failed)["PollCount"])
+
+	a.requeue(failed)
+	a.countPoll()
+	a.countPoll()
+	assert.Equal(t, 5.0, values(a.snapshot())["PollCount"], "failed report should be carried over")
+	assert.NotContains(t, values(a.snapshot()), "PollCount", "sent polls should not be reported again")
+}
+
+// flakySender fails every other send and records the reports it accepted.
+type flakySender struct {
+	mu       sync.Mutex
+	calls    int
+	accepted []map[string]float64
+}
+
+func (s *flakySender) SendMetrics(ctx context.Context, metrics []storage.Metric) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.calls%2 == 1 {
+		return errors.New("server unavailable")
+	}
+	s.accepted = append(s.accepted, values(metrics))
+	return nil
+}
+
+func TestAgent_PollCountSurvivesFailedReports(t *testing.T) {
+	collector := &MockCollector{metrics: []storage.Metric{storage.NewGauge("TestMetric", 1)}}
+	sender := &flakySender{}
+
+	a := NewAgent(
+		[]MetricsCollector{collector},
+		sender,
+		10*time.Millisecond,
+		30*time.Millisecond,
+		1,
+		"http://localhost:8080",
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	a.Run(ctx)
+
+	var sent float64
+	for _, m := range sender.accepted {
+		sent += m["PollCount"]
+	}
+	sent += values(a.snapshot())["PollCount"]
+
+	assert.Greater(t, sent, float64(0))
+	assert.InDelta(t, float64(collector.calls.Load()), sent, 1, "every poll should be reported exactly once")
+}
+
+func TestAgent_MergeTypedMetrics(t *testing.T) {
+	a := NewAgent(nil, &MockSender{}, time.Second, time.Second, 1, "http://localhost:8080")
+
+	h := storage.NewHistogram([]float64{1})
+	h.Observe(0.5)
+	latency := storage.Metric{ID: "Latency", MType: storage.Histogram, Histogram: h}
+	a.merge([]storage.Metric{storage.NewGauge("Load", 1), storage.NewCounter("Requests", 2), latency})
+	a.merge([]storage.Metric{storage.NewGauge("Load", 3), storage.NewCounter("Requests", 5), latency})
+
+	report := a.snapshot()
+	assert.Equal(t, map[string]float64{"Load": 3, "Requests": 7}, values(report), "gauges keep the last value, counters add up")
+	for _, m := range report {
+		if m.ID == "Latency" {
+			assert.Equal(t, uint64(2), m.Histogram.Count)
+		}
+	}
+	assert.Equal(t, uint64(1), h.Count, "collected histograms should not be modified")
+
+	assert.Equal(t, map[string]float64{"Load": 3}, values(a.snapshot()), "pending updates should be reported once")
+}
+
+func TestAgent_Filter(t *testing.T) {
+	collector := &MockCollector{metrics: []storage.Metric{
+		storage.NewGauge("Alloc", 1),
+		storage.NewGauge("CPUutilization1", 2),
+		storage.NewGauge("CPUutilization2", 3),
+		storage.NewGauge("Frees", 4),
+	}}
+	sender := &MockSender{}
+
+	a := NewAgent([]MetricsCollector{collector}, sender, 10*time.Millisecond, time.Hour, 1, "http://localhost:8080")
+	f, err := NewFilter([]string{"Alloc", "CPUutilization*", "PollCount"}, []string{"CPUutilization2"}, map[string]string{"Alloc": "heap_alloc"})
+	require.NoError(t, err)
+	a.SetFilter(f)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	a.Run(ctx)
+
+	require.Len(t, sender.sentMetrics, 1)
+	report := sender.sentMetrics[0]
+	assert.Equal(t, 1.0, report["heap_alloc"])
+	assert.Equal(t, 2.0, report["CPUutilization1"])
+	assert.Contains(t, report, "PollCount")
+	assert.NotContains(t, report, "Alloc")
+	assert.NotContains(t, report, "CPUutilization2")
+	assert.NotContains(t, report, "Frees")
+	assert.NotContains(t, report, "RandomValue")
+}
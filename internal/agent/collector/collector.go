@@ -2,6 +2,8 @@ package collector
 
 import (
 	"runtime"
+
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
 )
 
 type RuntimeCollector struct{}
@@ -10,11 +12,12 @@ func NewRuntimeCollector() *RuntimeCollector {
 	return &RuntimeCollector{}
 }
 
-func (c *RuntimeCollector) CollectMetrics() map[string]float64 {
+// CollectMetrics returns the runtime memory statistics as gauges.
+func (c *RuntimeCollector) CollectMetrics() []storage.Metric {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
-	metrics := map[string]float64{
+	stats := map[string]float64{
 		"Alloc":         float64(m.Alloc),
 		"BuckHashSys":   float64(m.BuckHashSys),
 		"Frees":         float64(m.Frees),
@@ -44,5 +47,13 @@ func (c *RuntimeCollector) CollectMetrics() map[string]float64 {
 		"TotalAlloc":    float64(m.TotalAlloc),
 	}
 
+	return gauges(stats)
+}
+
+func gauges(values map[string]float64) []storage.Metric {
+	metrics := make([]storage.Metric, 0, len(values))
+	for id, v := range values {
+		metrics = append(metrics, storage.NewGauge(id, v))
+	}
 	return metrics
 }
@@ -0,0 +1,174 @@
+package collector
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+// cpuTimes holds the busy and total jiffies of one core as read from
+// /proc/stat.
+type cpuTimes struct {
+	busy  uint64
+	total uint64
+}
+
+// SystemCollector reports host memory and per-core CPU utilization read from
+// procfs, in the spirit of gopsutil's mem.VirtualMemory and cpu.Percent.
+type SystemCollector struct {
+	procDir string
+
+	mu   sync.Mutex
+	prev []cpuTimes
+}
+
+func NewSystemCollector() *SystemCollector {
+	return &SystemCollector{procDir: "/proc"}
+}
+
+// CollectMetrics returns TotalMemory, FreeMemory and CPUutilization1..N as
+// gauges.
+// Utilization is measured since the previous call, or since boot on the
+// first one. Sources that cannot be read are logged and skipped.
+func (c *SystemCollector) CollectMetrics() []storage.Metric {
+	metrics := make(map[string]float64)
+
+	if total, free, err := c.readMemory(); err != nil {
+		log.Printf("failed to read memory stats: %v", err)
+	} else {
+		metrics["TotalMemory"] = total
+		metrics["FreeMemory"] = free
+	}
+
+	if utilization, err := c.readCPUUtilization(); err != nil {
+		log.Printf("failed to read cpu stats: %v", err)
+	} else {
+		for i, u := range utilization {
+			metrics[fmt.Sprintf("CPUutilization%d", i+1)] = u
+		}
+	}
+
+	return gauges(metrics)
+}
+
+// readMemory returns MemTotal and MemFree from /proc/meminfo in bytes.
+func (c *SystemCollector) readMemory() (total, free float64, err error) {
+	f, err := os.Open(filepath.Join(c.procDir, "meminfo"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var foundTotal, foundFree bool
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		var target *float64
+		switch fields[0] {
+		case "MemTotal:":
+			target, foundTotal = &total, true
+		case "MemFree:":
+			target, foundFree = &free, true
+		default:
+			continue
+		}
+
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid %s value: %w", fields[0], err)
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			v *= 1024
+		}
+		*target = float64(v)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if !foundTotal || !foundFree {
+		return 0, 0, fmt.Errorf("MemTotal or MemFree missing")
+	}
+	return total, free, nil
+}
+
+// readCPUUtilization returns busy percentage per core since the previous
+// sample.
+func (c *SystemCollector) readCPUUtilization() ([]float64, error) {
+	current, err := c.readCPUTimes()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]float64, len(current))
+	for i, cur := range current {
+		busy, total := cur.busy, cur.total
+		if i < len(c.prev) && cur.total >= c.prev[i].total && cur.busy >= c.prev[i].busy {
+			busy -= c.prev[i].busy
+			total -= c.prev[i].total
+		}
+		if total > 0 {
+			result[i] = float64(busy) / float64(total) * 100
+		}
+	}
+	c.prev = current
+	return result, nil
+}
+
+// readCPUTimes parses the per-core "cpuN" lines of /proc/stat, skipping the
+// aggregated "cpu" line.
+func (c *SystemCollector) readCPUTimes() ([]cpuTimes, error) {
+	f, err := os.Open(filepath.Join(c.procDir, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var result []cpuTimes
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") || fields[0] == "cpu" {
+			continue
+		}
+
+		var t cpuTimes
+		for i, field := range fields[1:] {
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s value: %w", fields[0], err)
+			}
+			// Columns 4 and 5 are idle and iowait; guest time is already
+			// included in user and nice, so columns 9 and 10 are skipped.
+			switch i {
+			case 3, 4:
+				t.total += v
+			case 8, 9:
+			default:
+				t.busy += v
+				t.total += v
+			}
+		}
+		result = append(result, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("no per-cpu lines found")
+	}
+	return result, nil
+}
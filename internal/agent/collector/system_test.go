@@ -0,0 +1,70 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+const testMeminfo = `MemTotal:        2048 kB
+MemFree:          512 kB
+MemAvailable:    1024 kB
+`
+
+// values returns the gauge values by name.
+func values(t *testing.T, metrics []storage.Metric) map[string]float64 {
+	t.Helper()
+	result := make(map[string]float64, len(metrics))
+	for _, m := range metrics {
+		require.Equal(t, storage.Gauge, m.MType, m.ID)
+		require.NotNil(t, m.Value, m.ID)
+		result[m.ID] = *m.Value
+	}
+	return result
+}
+
+func writeProc(t *testing.T, dir, stat string) {
+	t.Helper()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "meminfo"), []byte(testMeminfo), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0o644))
+}
+
+func TestSystemCollector(t *testing.T) {
+	dir := t.TempDir()
+	c := &SystemCollector{procDir: dir}
+
+	// user nice system idle iowait irq softirq steal guest guest_nice
+	writeProc(t, dir, `cpu  200 0 100 700 0 0 0 0 0 0
+cpu0 100 0 50 350 0 0 0 0 0 0
+cpu1 100 0 50 350 0 0 0 0 0 0
+intr 1 2 3
+`)
+	metrics := values(t, c.CollectMetrics())
+
+	assert.Equal(t, float64(2048*1024), metrics["TotalMemory"])
+	assert.Equal(t, float64(512*1024), metrics["FreeMemory"])
+	assert.InDelta(t, 30.0, metrics["CPUutilization1"], 0.001, "first sample is measured since boot")
+	assert.InDelta(t, 30.0, metrics["CPUutilization2"], 0.001)
+	assert.NotContains(t, metrics, "CPUutilization0")
+	assert.NotContains(t, metrics, "CPUutilization3")
+
+	// cpu0 spends the whole interval busy, cpu1 stays idle.
+	writeProc(t, dir, `cpu  300 0 100 800 0 0 0 0 0 0
+cpu0 200 0 50 350 0 0 0 0 0 0
+cpu1 100 0 50 450 0 0 0 0 0 0
+`)
+	metrics = values(t, c.CollectMetrics())
+
+	assert.InDelta(t, 100.0, metrics["CPUutilization1"], 0.001)
+	assert.InDelta(t, 0.0, metrics["CPUutilization2"], 0.001)
+}
+
+func TestSystemCollector_MissingProc(t *testing.T) {
+	c := &SystemCollector{procDir: filepath.Join(t.TempDir(), "missing")}
+
+	assert.Empty(t, c.CollectMetrics())
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+// Filter decides which collected metrics are reported and under which name.
+// Allow and Deny hold path.Match patterns matched against the collected
+// name: an empty Allow reports everything, and Deny wins over Allow.
+// Rename maps collected names to reported ones.
+type Filter struct {
+	Allow  []string
+	Deny   []string
+	Rename map[string]string
+}
+
+// NewFilter returns a filter after checking that its patterns are valid.
+func NewFilter(allow, deny []string, rename map[string]string) (Filter, error) {
+	for _, pattern := range append(append([]string(nil), allow...), deny...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return Filter{}, fmt.Errorf("invalid metric pattern %q: %w", pattern, err)
+		}
+	}
+	return Filter{Allow: allow, Deny: deny, Rename: rename}, nil
+}
+
+// Apply returns m renamed, or false when m is not reported.
+func (f Filter) Apply(m storage.Metric) (storage.Metric, bool) {
+	if len(f.Allow) > 0 && !matchAny(f.Allow, m.ID) {
+		return m, false
+	}
+	if matchAny(f.Deny, m.ID) {
+		return m, false
+	}
+	if name, ok := f.Rename[m.ID]; ok {
+		m.ID = name
+	}
+	return m, true
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
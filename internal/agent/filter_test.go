@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+func TestFilter_Apply(t *testing.T) {
+	f, err := NewFilter([]string{"Heap*", "Alloc"}, []string{"HeapIdle"}, map[string]string{"HeapAlloc": "heap_alloc"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		id     string
+		want   string
+		wantOK bool
+	}{
+		{name: "allowed", id: "Alloc", want: "Alloc", wantOK: true},
+		{name: "allowed by pattern and renamed", id: "HeapAlloc", want: "heap_alloc", wantOK: true},
+		{name: "denied wins over allowed", id: "HeapIdle", wantOK: false},
+		{name: "not allowed", id: "Frees", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ok := f.Apply(storage.NewGauge(tt.id, 1))
+			assert.Equal(t, tt.wantOK, ok)
+			if ok {
+				assert.Equal(t, tt.want, m.ID)
+			}
+		})
+	}
+}
+
+func TestFilter_EmptyAllowsEverything(t *testing.T) {
+	m, ok := Filter{}.Apply(storage.NewCounter("PollCount", 1))
+	assert.True(t, ok)
+	assert.Equal(t, "PollCount", m.ID)
+}
+
+func TestNewFilter_InvalidPattern(t *testing.T) {
+	_, err := NewFilter(nil, []string{"Heap["}, nil)
+	assert.Error(t, err)
+}
@@ -1,9 +1,18 @@
 package agent
 
+import (
+	"context"
+
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+// MetricsCollector returns typed metrics: gauges with their current value,
+// counters with the increase since the previous call and histograms with
+// the observations since the previous call.
 type MetricsCollector interface {
-	CollectMetrics() map[string]float64
+	CollectMetrics() []storage.Metric
 }
 
 type MetricsSender interface {
-	SendMetrics(metrics map[string]float64) error
+	SendMetrics(ctx context.Context, metrics []storage.Metric) error
 }
@@ -0,0 +1,113 @@
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"time"
+
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"github.com/yokitheyo/guardian-metrics/internal/pb"
+	"github.com/yokitheyo/guardian-metrics/internal/retry"
+	"github.com/yokitheyo/guardian-metrics/internal/sign"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// GRPCSender reports metrics through the MetricsService of the server.
+type GRPCSender struct {
+	conn        *grpc.ClientConn
+	realIP      *realIP
+	client      pb.MetricsServiceClient
+	key         string
+	labels      storage.Labels
+	identity    identity.Identity
+	retryDelays []time.Duration
+}
+
+// NewGRPCSender creates a sender calling the gRPC service at address. The
+// key, labels and id play the same part as for NewHTTPSender. A nil
+// tlsConfig connects without TLS. The connection is established lazily, on
+// the first send.
+func NewGRPCSender(address, key string, labels storage.Labels, id identity.Identity, tlsConfig *tls.Config) (*GRPCSender, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create grpc client: %w", err)
+	}
+	return &GRPCSender{
+		conn:        conn,
+		realIP:      newRealIP(address),
+		client:      pb.NewMetricsServiceClient(conn),
+		key:         key,
+		labels:      labels,
+		identity:    id,
+		retryDelays: retry.DefaultDelays,
+	}, nil
+}
+
+func (s *GRPCSender) SendMetrics(ctx context.Context, metrics []storage.Metric) error {
+	batch := make([]storage.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		m.Labels = withLabels(s.labels, m.Labels)
+		batch = append(batch, m)
+	}
+
+	return s.SendBatch(ctx, batch)
+}
+
+// SendBatch writes all metrics in a single UpdateMetrics call. Unavailable
+// servers are retried with backoff. Timed out attempts are not, as the
+// server may already have added the counters.
+func (s *GRPCSender) SendBatch(ctx context.Context, metrics []storage.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	req := &pb.UpdateMetricsRequest{Metrics: pb.FromMetrics(metrics)}
+	md := metadata.MD{}
+	if s.key != "" {
+		data, err := pb.MarshalSigned(req)
+		if err != nil {
+			return fmt.Errorf("failed to marshal metrics: %w", err)
+		}
+		md.Set(sign.Header, sign.Sum(data, s.key))
+	}
+	if s.identity.Source != "" {
+		md.Set(identity.AgentHeader, s.identity.Source)
+	}
+	if s.identity.Tenant != "" {
+		md.Set(identity.TenantHeader, s.identity.Tenant)
+	}
+	if ip := s.realIP.get(); ip != "" {
+		md.Set(identity.RealIPHeader, ip)
+	}
+	ctx = metadata.NewOutgoingContext(ctx, md)
+
+	return retry.Do(ctx, s.retryDelays, retry.IsRetriable, func() error {
+		attemptCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+
+		_, err := s.client.UpdateMetrics(attemptCtx, req)
+		switch status.Code(err) {
+		case codes.OK:
+			return nil
+		case codes.Unavailable:
+			return retry.Retriable(fmt.Errorf("failed to send metrics: %w", err))
+		default:
+			return fmt.Errorf("failed to send metrics: %w", err)
+		}
+	})
+}
+
+func (s *GRPCSender) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,121 @@
+package sender
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"github.com/yokitheyo/guardian-metrics/internal/pb"
+	"github.com/yokitheyo/guardian-metrics/internal/sign"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fakeMetricsService records the calls it receives and fails the first
+// failures of them with code.
+type fakeMetricsService struct {
+	pb.UnimplementedMetricsServiceServer
+
+	mu       sync.Mutex
+	requests []*pb.UpdateMetricsRequest
+	md       metadata.MD
+	calls    atomic.Int32
+	failures int32
+	code     codes.Code
+}
+
+func (f *fakeMetricsService) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if f.calls.Add(1) <= f.failures {
+		return nil, status.Error(f.code, "failing")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, req)
+	f.md, _ = metadata.FromIncomingContext(ctx)
+	return &pb.UpdateMetricsResponse{Accepted: uint64(len(req.GetMetrics()))}, nil
+}
+
+func startFakeService(t *testing.T, svc *fakeMetricsService) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	pb.RegisterMetricsServiceServer(srv, svc)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+func TestGRPCSender_SendMetrics(t *testing.T) {
+	svc := &fakeMetricsService{}
+	addr := startFakeService(t, svc)
+
+	s, err := NewGRPCSender(addr, "secret", storage.Labels{"host": "web1"}, identity.Identity{Source: "host-1", Tenant: "team-a"}, nil)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, s.SendMetrics(context.Background(), []storage.Metric{
+		storage.NewGauge("Alloc", 123.5),
+		storage.NewCounter("PollCount", 4),
+	}))
+
+	require.Len(t, svc.requests, 1, "all metrics should be sent in one call")
+	req := svc.requests[0]
+	require.Len(t, req.GetMetrics(), 2)
+	alloc := req.GetMetrics()[0].ToMetric()
+	assert.Equal(t, storage.Gauge, alloc.MType)
+	assert.Equal(t, 123.5, *alloc.Value)
+	assert.Equal(t, storage.Labels{"host": "web1"}, alloc.Labels)
+	pollCount := req.GetMetrics()[1].ToMetric()
+	assert.Equal(t, storage.Counter, pollCount.MType)
+	assert.Equal(t, int64(4), *pollCount.Delta)
+
+	assert.Equal(t, []string{"host-1"}, svc.md.Get(identity.AgentHeader))
+	assert.Equal(t, []string{"team-a"}, svc.md.Get(identity.TenantHeader))
+	assert.Equal(t, []string{"127.0.0.1"}, svc.md.Get(identity.RealIPHeader))
+	data, err := pb.MarshalSigned(req)
+	require.NoError(t, err)
+	require.Len(t, svc.md.Get(sign.Header), 1)
+	assert.True(t, sign.Verify(data, "secret", svc.md.Get(sign.Header)[0]))
+}
+
+func TestGRPCSender_Retries(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         codes.Code
+		wantErr      bool
+		wantAttempts int32
+	}{
+		{name: "unavailable is retried", code: codes.Unavailable, wantAttempts: 3},
+		{name: "invalid argument is not", code: codes.InvalidArgument, wantErr: true, wantAttempts: 1},
+		{name: "deadline exceeded is not", code: codes.DeadlineExceeded, wantErr: true, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &fakeMetricsService{failures: 2, code: tt.code}
+			addr := startFakeService(t, svc)
+
+			s, err := NewGRPCSender(addr, "", nil, identity.Identity{}, nil)
+			require.NoError(t, err)
+			defer s.Close()
+			s.retryDelays = []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+
+			err = s.SendMetrics(context.Background(), []storage.Metric{storage.NewGauge("Alloc", 1)})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantAttempts, svc.calls.Load())
+		})
+	}
+}
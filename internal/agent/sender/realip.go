@@ -0,0 +1,64 @@
+package sender
+
+import (
+	"net"
+	"net/url"
+	"sync"
+)
+
+// realIP caches the local address used to reach a server, so finding it
+// costs a socket only until it is first known.
+type realIP struct {
+	hostport string
+
+	mu sync.Mutex
+	ip string
+}
+
+func newRealIP(hostport string) *realIP {
+	return &realIP{hostport: hostport}
+}
+
+// get returns the cached address, looking it up while it is still unknown.
+func (r *realIP) get() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ip == "" {
+		r.ip = outboundIP(r.hostport)
+	}
+	return r.ip
+}
+
+// outboundIP returns the local address of the interface used to reach
+// hostport, or an empty string when there is no route to it. Connecting a
+// UDP socket picks the route without sending anything.
+func outboundIP(hostport string) string {
+	conn, err := net.Dial("udp", hostport)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	addr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return ""
+	}
+	return addr.IP.String()
+}
+
+// urlHostPort returns the host and port of a server URL, defaulting the port
+// by scheme.
+func urlHostPort(serverURL string) string {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return ""
+	}
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -1,54 +1,167 @@
 package sender
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"strconv"
+	"time"
+
+	"github.com/yokitheyo/guardian-metrics/internal/encryption"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"github.com/yokitheyo/guardian-metrics/internal/retry"
+	"github.com/yokitheyo/guardian-metrics/internal/sign"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
 )
 
+// requestTimeout bounds a single attempt; a timed out attempt is retried.
+const requestTimeout = 5 * time.Second
+
 type HTTPSender struct {
 	client        *http.Client
 	serverAddress string
+	key           string
+	labels        storage.Labels
+	identity      identity.Identity
+	publicKey     *rsa.PublicKey
+	realIP        *realIP
+	retryDelays   []time.Duration
 }
 
-func NewHTTPSender(serverAddress string) *HTTPSender {
+// NewHTTPSender creates a sender posting to serverAddress. A non-empty key
+// makes every request carry an HMAC-SHA256 signature of its body, labels
+// are attached to every metric sent through SendMetrics and id is sent in
+// the agent and tenant headers of every request. Requests also carry the
+// agent address in X-Real-IP.
+func NewHTTPSender(serverAddress, key string, labels storage.Labels, id identity.Identity) *HTTPSender {
 	return &HTTPSender{
-		client:        &http.Client{},
+		client:        &http.Client{Timeout: requestTimeout},
 		serverAddress: serverAddress,
+		key:           key,
+		labels:        labels,
+		identity:      id,
+		realIP:        newRealIP(urlHostPort(serverAddress)),
+		retryDelays:   retry.DefaultDelays,
 	}
 }
 
-func (s *HTTPSender) SendMetrics(metrics map[string]float64) error {
-	for name, value := range metrics {
-		metricType := "gauge"
-		if name == "PollCount" {
-			metricType = "counter"
-		}
+// SetPublicKey makes the sender encrypt request bodies with the server key.
+func (s *HTTPSender) SetPublicKey(key *rsa.PublicKey) {
+	s.publicKey = key
+}
 
-		url := fmt.Sprintf("%s/update/%s/%s/%s",
-			s.serverAddress,
-			metricType,
-			name,
-			strconv.FormatFloat(value, 'f', -1, 64),
-		)
+// SetTLSConfig makes the sender verify the server, and authenticate itself,
+// with cfg. The server address must use the https scheme.
+func (s *HTTPSender) SetTLSConfig(cfg *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = cfg
+	s.client.Transport = transport
+}
 
-		req, err := http.NewRequest(http.MethodPost, url, nil)
-		if err != nil {
-			return fmt.Errorf("failed to create request: %w", err)
-		}
+// SendMetrics posts metrics in one batch after attaching the sender labels.
+// Labels set on a metric win over sender labels of the same name.
+func (s *HTTPSender) SendMetrics(ctx context.Context, metrics []storage.Metric) error {
+	batch := make([]storage.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		m.Labels = withLabels(s.labels, m.Labels)
+		batch = append(batch, m)
+	}
 
-		req.Header.Set("Content-Type", "text/plain")
+	return s.SendBatch(ctx, batch)
+}
 
-		resp, err := s.client.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to send request: %w", err)
-		}
-		resp.Body.Close()
+// withLabels returns a new label set holding base overridden by extra.
+func withLabels(base, extra storage.Labels) storage.Labels {
+	if len(base) == 0 {
+		return extra
+	}
+	merged := base.Clone()
+	for name, value := range extra {
+		merged[name] = value
+	}
+	return merged
+}
+
+// SendBatch posts all metrics to the server in a single request, signed,
+// compressed and then encrypted when a public key is set. Connection errors,
+// timeouts and 5xx responses are retried with backoff.
+func (s *HTTPSender) SendBatch(ctx context.Context, metrics []storage.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
 
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	body, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	payload, err := compress(body)
+	if err != nil {
+		return fmt.Errorf("failed to compress metrics: %w", err)
+	}
+	if s.publicKey != nil {
+		if payload, err = encryption.Encrypt(s.publicKey, payload); err != nil {
+			return fmt.Errorf("failed to encrypt metrics: %w", err)
 		}
 	}
 
+	return retry.Do(ctx, s.retryDelays, retry.IsRetriable, func() error {
+		return s.post(ctx, body, payload)
+	})
+}
+
+func (s *HTTPSender) post(ctx context.Context, body, payload []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.serverAddress+"/updates/", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	if s.publicKey != nil {
+		req.Header.Set(encryption.Header, encryption.Scheme)
+	}
+	if s.key != "" {
+		req.Header.Set(sign.Header, sign.Sum(body, s.key))
+	}
+	if s.identity.Source != "" {
+		req.Header.Set(identity.AgentHeader, s.identity.Source)
+	}
+	if s.identity.Tenant != "" {
+		req.Header.Set(identity.TenantHeader, s.identity.Tenant)
+	}
+	if ip := s.realIP.get(); ip != "" {
+		req.Header.Set(identity.RealIPHeader, ip)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return retry.Retriable(fmt.Errorf("unexpected status code: %d", resp.StatusCode))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
 	return nil
 }
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,228 @@
+package sender
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/encryption"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"github.com/yokitheyo/guardian-metrics/internal/retry"
+	"github.com/yokitheyo/guardian-metrics/internal/sign"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+func TestHTTPSender_SendMetrics(t *testing.T) {
+	var (
+		requests int
+		received []storage.Metric
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "/updates/", r.URL.Path)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(zr).Decode(&received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	s := NewHTTPSender(ts.URL, "", nil, identity.Identity{})
+	err := s.SendMetrics(context.Background(), []storage.Metric{
+		storage.NewGauge("Alloc", 123.5),
+		storage.NewCounter("PollCount", 4),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, requests, "all metrics should be sent in one request")
+	require.Len(t, received, 2)
+
+	byID := make(map[string]storage.Metric)
+	for _, m := range received {
+		byID[m.ID] = m
+	}
+	require.NotNil(t, byID["Alloc"].Value)
+	assert.Equal(t, storage.Gauge, byID["Alloc"].MType)
+	assert.Equal(t, 123.5, *byID["Alloc"].Value)
+	require.NotNil(t, byID["PollCount"].Delta)
+	assert.Equal(t, storage.Counter, byID["PollCount"].MType)
+	assert.Equal(t, int64(4), *byID["PollCount"].Delta)
+}
+
+func TestHTTPSender_UnexpectedStatus(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	s := NewHTTPSender(ts.URL, "", nil, identity.Identity{})
+	err := s.SendMetrics(context.Background(), []storage.Metric{storage.NewGauge("Alloc", 1)})
+	assert.Error(t, err)
+}
+
+func TestHTTPSender_RetriesServerErrors(t *testing.T) {
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	s := NewHTTPSender(ts.URL, "", nil, identity.Identity{})
+	s.retryDelays = []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+
+	require.NoError(t, s.SendMetrics(context.Background(), []storage.Metric{storage.NewGauge("Alloc", 1)}))
+	assert.Equal(t, int32(3), attempts.Load())
+}
+
+func TestHTTPSender_DoesNotRetryClientErrors(t *testing.T) {
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	s := NewHTTPSender(ts.URL, "", nil, identity.Identity{})
+	s.retryDelays = []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+
+	assert.Error(t, s.SendMetrics(context.Background(), []storage.Metric{storage.NewGauge("Alloc", 1)}))
+	assert.Equal(t, int32(1), attempts.Load())
+}
+
+func TestHTTPSender_RetriesConnectionRefused(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	addr := ts.URL
+	ts.Close()
+
+	s := NewHTTPSender(addr, "", nil, identity.Identity{})
+	s.retryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+
+	err := s.SendMetrics(context.Background(), []storage.Metric{storage.NewGauge("Alloc", 1)})
+	require.Error(t, err)
+	assert.True(t, retry.IsRetriable(err))
+}
+
+func TestHTTPSender_SignsBody(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		body, err := io.ReadAll(zr)
+		require.NoError(t, err)
+
+		assert.True(t, sign.Verify(body, "secret", r.Header.Get(sign.Header)))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	s := NewHTTPSender(ts.URL, "secret", nil, identity.Identity{})
+	require.NoError(t, s.SendMetrics(context.Background(), []storage.Metric{storage.NewGauge("Alloc", 1)}))
+}
+
+func TestHTTPSender_AttachesLabels(t *testing.T) {
+	var received []storage.Metric
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(zr).Decode(&received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	labels := storage.Labels{"host": "web1", "service": "api"}
+	s := NewHTTPSender(ts.URL, "", labels, identity.Identity{})
+	alloc := storage.NewGauge("Alloc", 1)
+	alloc.Labels = storage.Labels{"service": "worker", "pool": "a"}
+	require.NoError(t, s.SendMetrics(context.Background(), []storage.Metric{alloc, storage.NewCounter("PollCount", 2)}))
+
+	require.Len(t, received, 2)
+	byID := make(map[string]storage.Metric)
+	for _, m := range received {
+		byID[m.ID] = m
+	}
+	assert.Equal(t, labels, byID["PollCount"].Labels)
+	assert.Equal(t, storage.Labels{"host": "web1", "service": "worker", "pool": "a"}, byID["Alloc"].Labels,
+		"metric labels should win over sender labels")
+	assert.Equal(t, storage.Labels{"service": "worker", "pool": "a"}, alloc.Labels, "the caller's labels should not change")
+}
+
+func TestHTTPSender_SendsIdentity(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "host-1", r.Header.Get(identity.AgentHeader))
+		assert.Equal(t, "team-a", r.Header.Get(identity.TenantHeader))
+		assert.Equal(t, "127.0.0.1", r.Header.Get(identity.RealIPHeader))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	s := NewHTTPSender(ts.URL, "", nil, identity.Identity{Source: "host-1", Tenant: "team-a"})
+	require.NoError(t, s.SendMetrics(context.Background(), []storage.Metric{storage.NewGauge("Alloc", 1)}))
+}
+
+func TestURLHostPort(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "http://localhost:8080", want: "localhost:8080"},
+		{url: "http://example.com", want: "example.com:80"},
+		{url: "https://example.com/path", want: "example.com:443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			assert.Equal(t, tt.want, urlHostPort(tt.url))
+		})
+	}
+}
+
+func TestRealIP_Cached(t *testing.T) {
+	r := newRealIP("127.0.0.1:8080")
+	assert.Equal(t, "127.0.0.1", r.get())
+
+	r.hostport = "invalid"
+	assert.Equal(t, "127.0.0.1", r.get(), "a known address is not looked up again")
+
+	assert.Empty(t, newRealIP("invalid").get())
+}
+
+func TestHTTPSender_EncryptsBody(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var received []storage.Metric
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, encryption.Scheme, r.Header.Get(encryption.Header))
+		message, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		compressed, err := encryption.Decrypt(key, message)
+		require.NoError(t, err)
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		require.NoError(t, err)
+		require.NoError(t, json.NewDecoder(zr).Decode(&received))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	s := NewHTTPSender(ts.URL, "", nil, identity.Identity{})
+	s.SetPublicKey(&key.PublicKey)
+	require.NoError(t, s.SendMetrics(context.Background(), []storage.Metric{storage.NewGauge("Alloc", 1)}))
+
+	require.Len(t, received, 1)
+	assert.Equal(t, "Alloc", received[0].ID)
+}
@@ -0,0 +1,172 @@
+package aggregate
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+var ErrUnknownFunc = errors.New("unknown aggregation function")
+
+const (
+	// MaxSteps bounds the number of steps one evaluation may walk.
+	MaxSteps = 11000
+	// MinStep is the smallest step an evaluation may take.
+	MinStep = time.Millisecond
+)
+
+// Steps returns how many times Evaluate applies the aggregation between
+// from and to, saturating at math.MaxInt64.
+func Steps(from, to time.Time, step time.Duration) int64 {
+	if step <= 0 || to.Before(from) {
+		return 1
+	}
+	n := int64(to.Sub(from) / step)
+	if n == math.MaxInt64 {
+		return n
+	}
+	return n + 1
+}
+
+// Func reduces the points of one window, oldest first, to a single value.
+// It reports false when the window holds too few points.
+type Func func(points []storage.Point) (float64, bool)
+
+// Lookup returns the aggregation called name. q is the quantile in [0, 1]
+// used by percentile and ignored otherwise.
+func Lookup(name string, q float64) (Func, error) {
+	switch name {
+	case "min":
+		return Min, nil
+	case "max":
+		return Max, nil
+	case "avg":
+		return Avg, nil
+	case "sum":
+		return Sum, nil
+	case "rate":
+		return Rate, nil
+	case "percentile":
+		if q < 0 || q > 1 || math.IsNaN(q) {
+			return nil, fmt.Errorf("quantile %v is out of [0, 1]", q)
+		}
+		return Percentile(q), nil
+	default:
+		return nil, fmt.Errorf("%w %q", ErrUnknownFunc, name)
+	}
+}
+
+func Min(points []storage.Point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+	result := points[0].Value
+	for _, p := range points[1:] {
+		result = math.Min(result, p.Value)
+	}
+	return result, true
+}
+
+func Max(points []storage.Point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+	result := points[0].Value
+	for _, p := range points[1:] {
+		result = math.Max(result, p.Value)
+	}
+	return result, true
+}
+
+func Sum(points []storage.Point) (float64, bool) {
+	if len(points) == 0 {
+		return 0, false
+	}
+	var result float64
+	for _, p := range points {
+		result += p.Value
+	}
+	return result, true
+}
+
+func Avg(points []storage.Point) (float64, bool) {
+	sum, ok := Sum(points)
+	if !ok {
+		return 0, false
+	}
+	return sum / float64(len(points)), true
+}
+
+// Rate returns the per second increase of a counter over the window. A
+// value lower than the one before it means the counter was reset, e.g. by
+// an agent restart, so the increase since the reset is the value itself.
+func Rate(points []storage.Point) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+	elapsed := points[len(points)-1].Timestamp.Sub(points[0].Timestamp).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+
+	var increase float64
+	for i := 1; i < len(points); i++ {
+		prev, cur := points[i-1].Value, points[i].Value
+		if cur < prev {
+			increase += cur
+		} else {
+			increase += cur - prev
+		}
+	}
+	return increase / elapsed, true
+}
+
+// Percentile returns the q-quantile of the values, interpolating linearly
+// between the closest ranks.
+func Percentile(q float64) Func {
+	return func(points []storage.Point) (float64, bool) {
+		if len(points) == 0 {
+			return 0, false
+		}
+		sorted := make([]float64, len(points))
+		for i, p := range points {
+			sorted[i] = p.Value
+		}
+		sort.Float64s(sorted)
+
+		rank := q * float64(len(sorted)-1)
+		lower := int(math.Floor(rank))
+		upper := int(math.Ceil(rank))
+		frac := rank - float64(lower)
+		return sorted[lower] + (sorted[upper]-sorted[lower])*frac, true
+	}
+}
+
+// Evaluate applies fn at every step from from to to, each time over the
+// points in the window (t-window, t]. Steps whose window yields no value
+// are left out. A non-positive step evaluates only at to. Callers bound
+// the work with Steps.
+func Evaluate(points []storage.Point, fn Func, from, to time.Time, window, step time.Duration) []storage.Point {
+	var result []storage.Point
+	eval := func(t time.Time) {
+		start := t.Add(-window)
+		lo := sort.Search(len(points), func(i int) bool { return points[i].Timestamp.After(start) })
+		hi := sort.Search(len(points), func(i int) bool { return points[i].Timestamp.After(t) })
+		if v, ok := fn(points[lo:hi]); ok {
+			result = append(result, storage.Point{Timestamp: t, Value: v})
+		}
+	}
+
+	if step <= 0 {
+		eval(to)
+		return result
+	}
+	for t := from; !t.After(to); t = t.Add(step) {
+		eval(t)
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+package aggregate
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// series builds points ten seconds apart.
+func series(values ...float64) []storage.Point {
+	points := make([]storage.Point, len(values))
+	for i, v := range values {
+		points[i] = storage.Point{Timestamp: start.Add(time.Duration(i) * 10 * time.Second), Value: v}
+	}
+	return points
+}
+
+func TestFuncs(t *testing.T) {
+	points := series(4, 1, 3, 2)
+
+	tests := []struct {
+		name     string
+		q        float64
+		expected float64
+	}{
+		{name: "min", expected: 1},
+		{name: "max", expected: 4},
+		{name: "sum", expected: 10},
+		{name: "avg", expected: 2.5},
+		{name: "percentile", q: 0.5, expected: 2.5},
+		{name: "percentile", q: 1, expected: 4},
+		{name: "percentile", q: 0, expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn, err := Lookup(tt.name, tt.q)
+			require.NoError(t, err)
+
+			v, ok := fn(points)
+			require.True(t, ok)
+			assert.InDelta(t, tt.expected, v, 1e-9)
+
+			_, ok = fn(nil)
+			assert.False(t, ok, "an empty window has no value")
+		})
+	}
+}
+
+func TestLookupErrors(t *testing.T) {
+	_, err := Lookup("median", 0)
+	assert.ErrorIs(t, err, ErrUnknownFunc)
+
+	_, err = Lookup("percentile", 1.5)
+	assert.Error(t, err)
+}
+
+func TestRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		points   []storage.Point
+		expected float64
+		ok       bool
+	}{
+		{name: "steady counter", points: series(0, 10, 20, 30), expected: 1, ok: true},
+		{name: "reset after agent restart", points: series(100, 110, 5, 15), expected: 25.0 / 30, ok: true},
+		{name: "single point", points: series(10), ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, ok := Rate(tt.points)
+			assert.Equal(t, tt.ok, ok)
+			assert.InDelta(t, tt.expected, v, 1e-9)
+		})
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	points := series(1, 2, 3, 4, 5, 6)
+
+	result := Evaluate(points, Max, start, start.Add(50*time.Second), 20*time.Second, 20*time.Second)
+	require.Len(t, result, 3)
+	assert.Equal(t, []float64{1, 3, 5}, []float64{result[0].Value, result[1].Value, result[2].Value})
+	assert.Equal(t, start.Add(40*time.Second), result[2].Timestamp)
+
+	result = Evaluate(points, Avg, start, start.Add(50*time.Second), 30*time.Second, 0)
+	require.Len(t, result, 1)
+	assert.Equal(t, 5.0, result[0].Value, "only the window ending at to is used")
+
+	result = Evaluate(points, Rate, start, start.Add(50*time.Second), 5*time.Second, 10*time.Second)
+	assert.Empty(t, result, "windows with one point have no rate")
+}
+
+func TestSteps(t *testing.T) {
+	assert.Equal(t, int64(3), Steps(start, start.Add(50*time.Second), 20*time.Second))
+	assert.Equal(t, int64(1), Steps(start, start.Add(time.Hour), 0), "without a step only to is evaluated")
+	assert.Greater(t, Steps(time.Unix(0, 0), start, time.Nanosecond), int64(MaxSteps))
+	assert.Equal(t, int64(math.MaxInt64), Steps(time.Time{}, start, time.Nanosecond), "does not wrap around")
+}
@@ -0,0 +1,299 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// resolvedRetention is how long a resolved alert stays listed.
+const resolvedRetention = 15 * time.Minute
+
+// notifyQueueSize bounds the evaluations whose notifications may wait for
+// a slow notifier before newer ones are dropped.
+const notifyQueueSize = 16
+
+type State string
+
+const (
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// Alert is the state of one rule for one series.
+type Alert struct {
+	Rule       string             `json:"rule"`
+	State      State              `json:"state"`
+	ID         string             `json:"id"`
+	MType      storage.MetricType `json:"type"`
+	Labels     storage.Labels     `json:"labels,omitempty"`
+	Source     string             `json:"source,omitempty"`
+	Tenant     string             `json:"tenant,omitempty"`
+	Value      *float64           `json:"value,omitempty"`
+	Summary    string             `json:"summary"`
+	ActiveAt   time.Time          `json:"active_at"`
+	ResolvedAt *time.Time         `json:"resolved_at,omitempty"`
+}
+
+// Evaluator periodically checks rules against the storage and tracks the
+// resulting alerts.
+type Evaluator struct {
+	storage  storage.Storage
+	rules    []Rule
+	notifier Notifier
+	interval time.Duration
+	logger   *zap.Logger
+	now      func() time.Time
+	started  time.Time
+
+	mu     sync.RWMutex
+	alerts map[string]*Alert
+}
+
+// NewEvaluator creates an evaluator checking rules every interval. A nil
+// notifier only tracks state.
+func NewEvaluator(s storage.Storage, rules []Rule, notifier Notifier, interval time.Duration, logger *zap.Logger) *Evaluator {
+	return &Evaluator{
+		storage:  s,
+		rules:    rules,
+		notifier: notifier,
+		interval: interval,
+		logger:   logger,
+		now:      time.Now,
+		alerts:   make(map[string]*Alert),
+	}
+}
+
+// Run evaluates the rules until ctx is cancelled. Notifications are sent
+// in the background, so a slow notifier delays neither the next evaluation
+// nor Alerts.
+func (e *Evaluator) Run(ctx context.Context) {
+	if len(e.rules) == 0 {
+		return
+	}
+	e.started = e.now()
+
+	queue := make(chan []Alert, notifyQueueSize)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for alerts := range queue {
+			e.notify(ctx, alerts)
+		}
+	}()
+	defer func() {
+		close(queue)
+		<-sent
+	}()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			alerts := e.update(e.now())
+			if len(alerts) == 0 || e.notifier == nil {
+				continue
+			}
+			select {
+			case queue <- alerts:
+			default:
+				e.logger.Error("alert notifications are falling behind, dropping", zap.Int("alerts", len(alerts)))
+			}
+		}
+	}
+}
+
+// Alerts returns the pending, firing and recently resolved alerts sorted by
+// rule and series.
+func (e *Evaluator) Alerts() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	result := make([]Alert, 0, len(e.alerts))
+	keys := make([]string, 0, len(e.alerts))
+	for key := range e.alerts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		result = append(result, *e.alerts[key])
+	}
+	return result
+}
+
+// candidate is a series for which a rule's condition holds.
+type candidate struct {
+	key    string
+	metric storage.Metric
+	value  *float64
+}
+
+// evaluate checks the rules once and sends the notifications right away.
+func (e *Evaluator) evaluate(ctx context.Context) {
+	e.notify(ctx, e.update(e.now()))
+}
+
+// update checks the rules against the storage and returns the alerts that
+// started firing or got resolved. Storage is queried before e.mu is taken,
+// which is only held to apply the results.
+func (e *Evaluator) update(now time.Time) []Alert {
+	active := make([][]candidate, len(e.rules))
+	for i, rule := range e.rules {
+		active[i] = e.active(rule, now)
+	}
+
+	var transitions []Alert
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	seen := make(map[string]bool)
+	for i, rule := range e.rules {
+		for _, c := range active[i] {
+			seen[c.key] = true
+			if a := e.activate(rule, c, now); a != nil {
+				transitions = append(transitions, *a)
+			}
+		}
+	}
+	for key, a := range e.alerts {
+		if seen[key] {
+			continue
+		}
+		switch a.State {
+		case StatePending:
+			delete(e.alerts, key)
+		case StateFiring:
+			a.State = StateResolved
+			resolvedAt := now
+			a.ResolvedAt = &resolvedAt
+			transitions = append(transitions, *a)
+		case StateResolved:
+			if now.Sub(*a.ResolvedAt) >= resolvedRetention {
+				delete(e.alerts, key)
+			}
+		}
+	}
+	return transitions
+}
+
+func (e *Evaluator) notify(ctx context.Context, alerts []Alert) {
+	if e.notifier == nil {
+		return
+	}
+	for _, a := range alerts {
+		if err := e.notifier.Notify(ctx, a); err != nil {
+			e.logger.Error("failed to send alert notification",
+				zap.String("rule", a.Rule), zap.String("state", string(a.State)), zap.Error(err))
+		}
+	}
+}
+
+// activate must be called with e.mu held. It moves the alert of c towards
+// firing and returns it when it has just started firing.
+func (e *Evaluator) activate(rule Rule, c candidate, now time.Time) *Alert {
+	a, ok := e.alerts[c.key]
+	if !ok || a.State == StateResolved {
+		a = &Alert{
+			Rule:     rule.Name,
+			State:    StatePending,
+			ID:       c.metric.ID,
+			MType:    c.metric.MType,
+			Labels:   c.metric.Labels,
+			Source:   c.metric.Source,
+			Tenant:   c.metric.Tenant,
+			ActiveAt: now,
+		}
+		e.alerts[c.key] = a
+	}
+	a.Value = c.value
+	a.Summary = summary(rule, c.value)
+
+	if a.State == StatePending && now.Sub(a.ActiveAt) >= rule.For {
+		a.State = StateFiring
+		return a
+	}
+	return nil
+}
+
+// active returns the series for which the condition of rule holds now.
+func (e *Evaluator) active(rule Rule, now time.Time) []candidate {
+	series := e.storage.Query(rule.selector())
+
+	if rule.AbsentFor > 0 {
+		// History from before the evaluator started may be missing, as
+		// with series restored from a file, so nothing counts as absent
+		// for longer than the evaluator has been running.
+		if now.Sub(e.started) < rule.AbsentFor {
+			return nil
+		}
+		if len(series) == 0 {
+			m := storage.Metric{ID: rule.Metric, MType: rule.Type, Labels: rule.Labels, Source: rule.Source, Tenant: rule.Tenant}
+			return []candidate{{key: alertKey(rule, m), metric: m}}
+		}
+
+		var result []candidate
+		for _, m := range series {
+			if !e.absent(m, now.Add(-rule.AbsentFor), now) {
+				continue
+			}
+			result = append(result, candidate{key: alertKey(rule, m), metric: m, value: valueOf(m)})
+		}
+		return result
+	}
+
+	var result []candidate
+	for _, m := range series {
+		v := valueOf(m)
+		if v == nil || !ops[rule.Op](*v, rule.Threshold) {
+			continue
+		}
+		result = append(result, candidate{key: alertKey(rule, m), metric: m, value: v})
+	}
+	return result
+}
+
+// absent reports whether m has no recorded points within [from, to]. A
+// storage without history cannot tell, so its series are never absent.
+func (e *Evaluator) absent(m storage.Metric, from, to time.Time) bool {
+	points, err := e.storage.Range(m, from, to)
+	if err != nil {
+		if !errors.Is(err, storage.ErrHistoryUnsupported) {
+			e.logger.Error("failed to read history", zap.String("id", m.ID), zap.Error(err))
+		}
+		return false
+	}
+	return len(points) == 0
+}
+
+func valueOf(m storage.Metric) *float64 {
+	switch {
+	case m.Value != nil:
+		v := *m.Value
+		return &v
+	case m.Delta != nil:
+		v := float64(*m.Delta)
+		return &v
+	}
+	return nil
+}
+
+func alertKey(rule Rule, m storage.Metric) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s", rule.Name, m.Tenant, m.Source, m.ID, m.Labels.Key())
+}
+
+func summary(rule Rule, value *float64) string {
+	if rule.AbsentFor > 0 {
+		return fmt.Sprintf("%s has not been reported for %s", rule.Metric, rule.AbsentFor)
+	}
+	return fmt.Sprintf("%s is %v, %s %v", rule.Metric, *value, rule.Op, rule.Threshold)
+}
@@ -0,0 +1,246 @@
+package alerting
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+	"go.uber.org/zap"
+)
+
+type recordingNotifier struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, a Alert) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.alerts = append(n.alerts, a)
+	return nil
+}
+
+func (n *recordingNotifier) states() []State {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	result := make([]State, 0, len(n.alerts))
+	for _, a := range n.alerts {
+		result = append(result, a.State)
+	}
+	return result
+}
+
+// newTestEvaluator returns an evaluator with a clock advanced by tick.
+func newTestEvaluator(s storage.Storage, rules []Rule, n Notifier) (*Evaluator, func(time.Duration)) {
+	e := NewEvaluator(s, rules, n, time.Second, zap.NewNop())
+	now := time.Now()
+	e.now = func() time.Time { return now }
+	e.started = now
+	return e, func(d time.Duration) { now = now.Add(d) }
+}
+
+func setGauge(t *testing.T, s storage.Storage, id string, v float64) {
+	t.Helper()
+	require.NoError(t, s.UpdateMetric(storage.Metric{ID: id, MType: storage.Gauge, Value: &v}))
+}
+
+func TestEvaluator_Threshold(t *testing.T) {
+	s := storage.NewMemStorage()
+	n := &recordingNotifier{}
+	e, tick := newTestEvaluator(s, []Rule{
+		{Name: "HighHeap", Metric: "HeapInuse", Type: storage.Gauge, Op: ">", Threshold: 100, For: time.Minute},
+	}, n)
+	ctx := context.Background()
+
+	setGauge(t, s, "HeapInuse", 50)
+	e.evaluate(ctx)
+	assert.Empty(t, e.Alerts())
+
+	setGauge(t, s, "HeapInuse", 150)
+	e.evaluate(ctx)
+	alerts := e.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StatePending, alerts[0].State)
+	assert.Equal(t, 150.0, *alerts[0].Value)
+
+	tick(time.Minute)
+	e.evaluate(ctx)
+	alerts = e.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StateFiring, alerts[0].State)
+
+	setGauge(t, s, "HeapInuse", 80)
+	tick(time.Second)
+	e.evaluate(ctx)
+	alerts = e.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StateResolved, alerts[0].State)
+	require.NotNil(t, alerts[0].ResolvedAt)
+
+	tick(resolvedRetention)
+	e.evaluate(ctx)
+	assert.Empty(t, e.Alerts())
+
+	assert.Equal(t, []State{StateFiring, StateResolved}, n.states())
+}
+
+func TestEvaluator_PendingClearsWithoutNotification(t *testing.T) {
+	s := storage.NewMemStorage()
+	n := &recordingNotifier{}
+	e, tick := newTestEvaluator(s, []Rule{
+		{Name: "HighHeap", Metric: "HeapInuse", Type: storage.Gauge, Op: ">=", Threshold: 100, For: time.Minute},
+	}, n)
+
+	setGauge(t, s, "HeapInuse", 100)
+	e.evaluate(context.Background())
+	require.Len(t, e.Alerts(), 1)
+
+	setGauge(t, s, "HeapInuse", 99)
+	tick(30 * time.Second)
+	e.evaluate(context.Background())
+	assert.Empty(t, e.Alerts())
+	assert.Empty(t, n.states())
+}
+
+func TestEvaluator_PerSeries(t *testing.T) {
+	s := storage.NewMemStorage()
+	e, _ := newTestEvaluator(s, []Rule{
+		{Name: "HighHeap", Metric: "HeapInuse", Type: storage.Gauge, Op: ">", Threshold: 100},
+	}, nil)
+
+	a, b := 150.0, 200.0
+	require.NoError(t, s.UpdateMetrics([]storage.Metric{
+		{ID: "HeapInuse", MType: storage.Gauge, Value: &a, Source: "host-1"},
+		{ID: "HeapInuse", MType: storage.Gauge, Value: &b, Source: "host-2"},
+	}))
+	e.evaluate(context.Background())
+
+	alerts := e.Alerts()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, StateFiring, alerts[0].State, "a rule without for fires at once")
+	assert.Equal(t, "host-1", alerts[0].Source)
+	assert.Equal(t, "host-2", alerts[1].Source)
+}
+
+func TestEvaluator_Absent(t *testing.T) {
+	s := storage.NewMemStorage()
+	d := int64(1)
+	require.NoError(t, s.UpdateMetric(storage.Metric{ID: "PollCount", MType: storage.Counter, Delta: &d}))
+
+	n := &recordingNotifier{}
+	e, tick := newTestEvaluator(s, []Rule{
+		{Name: "AgentDown", Metric: "PollCount", Type: storage.Counter, AbsentFor: time.Minute},
+		{Name: "NeverSeen", Metric: "Missing", Type: storage.Gauge, AbsentFor: time.Minute},
+	}, n)
+	ctx := context.Background()
+
+	e.evaluate(ctx)
+	assert.Empty(t, e.Alerts(), "reported recently, and too early to call Missing absent")
+
+	tick(2 * time.Minute)
+	e.evaluate(ctx)
+	alerts := e.Alerts()
+	require.Len(t, alerts, 2)
+	assert.Equal(t, "AgentDown", alerts[0].Rule)
+	assert.Equal(t, StateFiring, alerts[0].State)
+	assert.Equal(t, "NeverSeen", alerts[1].Rule)
+	assert.Equal(t, StateFiring, alerts[1].State)
+	assert.Contains(t, alerts[1].Summary, "has not been reported")
+}
+
+func TestEvaluator_AbsentWithoutEarlierHistory(t *testing.T) {
+	// A series restored from a file has a value but no history yet.
+	s := storage.NewMemStorage()
+	setGauge(t, s, "Alloc", 1)
+	s.SetRetention(storage.DefaultRetention)
+
+	e, tick := newTestEvaluator(s, []Rule{
+		{Name: "AgentDown", Metric: "Alloc", Type: storage.Gauge, AbsentFor: time.Minute},
+	}, nil)
+	ctx := context.Background()
+
+	e.evaluate(ctx)
+	assert.Empty(t, e.Alerts(), "not absent for longer than the evaluator has run")
+
+	tick(2 * time.Minute)
+	e.evaluate(ctx)
+	alerts := e.Alerts()
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StateFiring, alerts[0].State)
+}
+
+func TestEvaluator_RunStopsOnCancel(t *testing.T) {
+	e := NewEvaluator(storage.NewMemStorage(), []Rule{
+		{Name: "HighHeap", Metric: "HeapInuse", Type: storage.Gauge, Op: ">", Threshold: 100},
+	}, nil, time.Millisecond, zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("evaluator did not stop")
+	}
+}
+
+// blockingNotifier holds every notification until release is closed.
+type blockingNotifier struct {
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(ctx context.Context, _ Alert) error {
+	select {
+	case <-n.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func TestEvaluator_SlowNotifierDoesNotBlock(t *testing.T) {
+	s := storage.NewMemStorage()
+	n := &blockingNotifier{release: make(chan struct{})}
+	defer close(n.release)
+	e := NewEvaluator(s, []Rule{
+		{Name: "HighHeap", Metric: "HeapInuse", Type: storage.Gauge, Op: ">", Threshold: 100},
+	}, n, time.Millisecond, zap.NewNop())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+
+	state := func() State {
+		alerts := e.Alerts()
+		if len(alerts) == 0 {
+			return ""
+		}
+		return alerts[0].State
+	}
+
+	setGauge(t, s, "HeapInuse", 150)
+	require.Eventually(t, func() bool { return state() == StateFiring }, time.Second, time.Millisecond)
+
+	setGauge(t, s, "HeapInuse", 50)
+	assert.Eventually(t, func() bool { return state() == StateResolved }, time.Second, time.Millisecond,
+		"evaluations should go on while the firing notification is stuck")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("evaluator did not stop")
+	}
+}
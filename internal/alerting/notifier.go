@@ -0,0 +1,64 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/yokitheyo/guardian-metrics/internal/retry"
+)
+
+const webhookTimeout = 5 * time.Second
+
+// Notifier is told about alerts that start firing or get resolved.
+type Notifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// WebhookNotifier posts every alert as JSON to a URL, retrying connection
+// errors and 5xx responses.
+type WebhookNotifier struct {
+	client      *http.Client
+	url         string
+	retryDelays []time.Duration
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		client:      &http.Client{Timeout: webhookTimeout},
+		url:         url,
+		retryDelays: retry.DefaultDelays,
+	}
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to marshal alert: %w", err)
+	}
+
+	return retry.Do(ctx, n.retryDelays, retry.IsRetriable, func() error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := n.client.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to send alert: %w", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return retry.Retriable(fmt.Errorf("unexpected status code: %d", resp.StatusCode))
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var received Alert
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	n := NewWebhookNotifier(ts.URL)
+	require.NoError(t, n.Notify(context.Background(), Alert{Rule: "HighHeap", State: StateFiring, ID: "HeapInuse"}))
+	assert.Equal(t, "HighHeap", received.Rule)
+	assert.Equal(t, StateFiring, received.State)
+}
+
+func TestWebhookNotifier_Retries(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		expectedCalls int32
+	}{
+		{name: "server error is retried", status: http.StatusBadGateway, expectedCalls: 3},
+		{name: "client error is not retried", status: http.StatusBadRequest, expectedCalls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			n := NewWebhookNotifier(ts.URL)
+			n.retryDelays = []time.Duration{time.Millisecond, time.Millisecond}
+
+			assert.Error(t, n.Notify(context.Background(), Alert{Rule: "HighHeap"}))
+			assert.Equal(t, tt.expectedCalls, calls.Load())
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is either a threshold rule, firing when a series compares to
+// Threshold with Op for at least For, or an absence rule, firing when a
+// series has not been reported for AbsentFor.
+type Rule struct {
+	Name   string             `yaml:"name"`
+	Metric string             `yaml:"metric"`
+	Type   storage.MetricType `yaml:"type"`
+	// Labels, Source and Tenant narrow the series the rule applies to, as in
+	// storage.Selector.
+	Labels storage.Labels `yaml:"labels"`
+	Source string         `yaml:"source"`
+	Tenant string         `yaml:"tenant"`
+
+	Op        string        `yaml:"op"`
+	Threshold float64       `yaml:"threshold"`
+	For       time.Duration `yaml:"for"`
+	AbsentFor time.Duration `yaml:"absent_for"`
+}
+
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+var ops = map[string]func(a, b float64) bool{
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+}
+
+func (r Rule) selector() storage.Selector {
+	return storage.Selector{MType: r.Type, ID: r.Metric, Matchers: r.Labels, Source: r.Source, Tenant: r.Tenant}
+}
+
+func (r Rule) validate() error {
+	if r.Name == "" {
+		return errors.New("name is empty")
+	}
+	if r.Metric == "" {
+		return errors.New("metric is empty")
+	}
+	if r.Type != storage.Gauge && r.Type != storage.Counter {
+		return fmt.Errorf("invalid type %q", r.Type)
+	}
+	if err := r.Labels.Validate(); err != nil {
+		return err
+	}
+	if r.For < 0 || r.AbsentFor < 0 {
+		return errors.New("durations must not be negative")
+	}
+	switch {
+	case r.Op != "" && r.AbsentFor > 0:
+		return errors.New("op and absent_for are mutually exclusive")
+	case r.AbsentFor > 0:
+		return nil
+	case r.Op == "":
+		return errors.New("either op or absent_for is required")
+	case ops[r.Op] == nil:
+		return fmt.Errorf("invalid op %q", r.Op)
+	}
+	return nil
+}
+
+// ParseRules decodes and validates a YAML rules document.
+func ParseRules(data []byte) ([]Rule, error) {
+	var f rulesFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+
+	names := make(map[string]bool, len(f.Rules))
+	for i, r := range f.Rules {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("rule %d (%s): %w", i+1, r.Name, err)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("rule %d: duplicate name %q", i+1, r.Name)
+		}
+		names[r.Name] = true
+	}
+	return f.Rules, nil
+}
+
+// LoadRules reads the rules from a YAML file.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules: %w", err)
+	}
+	return ParseRules(data)
+}
@@ -0,0 +1,81 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]byte(`
+rules:
+  - name: HighHeap
+    metric: HeapInuse
+    type: gauge
+    labels:
+      host: web1
+    op: ">"
+    threshold: 1e9
+    for: 1m
+  - name: AgentDown
+    metric: PollCount
+    type: counter
+    source: host-1
+    tenant: team-a
+    absent_for: 2m30s
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+
+	assert.Equal(t, Rule{
+		Name: "HighHeap", Metric: "HeapInuse", Type: storage.Gauge,
+		Labels: storage.Labels{"host": "web1"},
+		Op:     ">", Threshold: 1e9, For: time.Minute,
+	}, rules[0])
+	assert.Equal(t, Rule{
+		Name: "AgentDown", Metric: "PollCount", Type: storage.Counter,
+		Source: "host-1", Tenant: "team-a", AbsentFor: 150 * time.Second,
+	}, rules[1])
+}
+
+func TestParseRules_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{name: "malformed yaml", yaml: "rules: [\n"},
+		{name: "missing name", yaml: "rules: [{metric: A, type: gauge, op: '>'}]"},
+		{name: "missing metric", yaml: "rules: [{name: r, type: gauge, op: '>'}]"},
+		{name: "bad type", yaml: "rules: [{name: r, metric: A, type: histogram, op: '>'}]"},
+		{name: "bad op", yaml: "rules: [{name: r, metric: A, type: gauge, op: '=>'}]"},
+		{name: "no condition", yaml: "rules: [{name: r, metric: A, type: gauge}]"},
+		{name: "both conditions", yaml: "rules: [{name: r, metric: A, type: gauge, op: '>', absent_for: 1m}]"},
+		{name: "negative for", yaml: "rules: [{name: r, metric: A, type: gauge, op: '>', for: -1m}]"},
+		{name: "bad label", yaml: "rules: [{name: r, metric: A, type: gauge, op: '>', labels: {1x: y}}]"},
+		{name: "duplicate name", yaml: "rules: [{name: r, metric: A, type: gauge, op: '>'}, {name: r, metric: B, type: gauge, op: '<'}]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRules([]byte(tt.yaml))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("rules: [{name: r, metric: A, type: gauge, op: '>', threshold: 1}]"), 0o644))
+
+	rules, err := LoadRules(path)
+	require.NoError(t, err)
+	assert.Len(t, rules, 1)
+
+	_, err = LoadRules(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
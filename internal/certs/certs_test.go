@@ -0,0 +1,90 @@
+package certs
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/certs/certstest"
+)
+
+// serverName returns the common name of the certificate the server at url
+// presents.
+func serverName(t *testing.T, url string, cfg *tls.Config) string {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	ca := certstest.NewCA(t, "test")
+	certPath, keyPath := ca.Server("first")
+
+	r, err := NewReloader(certPath, keyPath, "")
+	require.NoError(t, err)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	ts.TLS = r.TLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	clientCfg, err := ClientConfig(ca.CertPath, "", "")
+	require.NoError(t, err)
+	clientCfg.ServerName = "localhost"
+	assert.Equal(t, "first", serverName(t, ts.URL, clientCfg))
+
+	secondCert, secondKey := ca.Server("second")
+	require.NoError(t, os.Rename(secondCert, certPath))
+	require.NoError(t, os.Rename(secondKey, keyPath))
+	require.NoError(t, r.Reload())
+	assert.Equal(t, "second", serverName(t, ts.URL, clientCfg), "new connections get the reloaded certificate")
+
+	require.NoError(t, os.WriteFile(certPath, []byte("garbage"), 0o600))
+	assert.Error(t, r.Reload())
+	assert.Equal(t, "second", serverName(t, ts.URL, clientCfg), "a failed reload keeps the previous certificate")
+}
+
+func TestReloader_ClientCA(t *testing.T) {
+	ca := certstest.NewCA(t, "test")
+	certPath, keyPath := ca.Server("server")
+	clientCert, clientKey := ca.Client("agent-1")
+
+	r, err := NewReloader(certPath, keyPath, ca.CertPath)
+	require.NoError(t, err)
+
+	var commonName string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commonName = r.TLS.VerifiedChains[0][0].Subject.CommonName
+	}))
+	ts.TLS = r.TLSConfig()
+	ts.StartTLS()
+	defer ts.Close()
+
+	withoutCert, err := ClientConfig(ca.CertPath, "", "")
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: &http.Transport{TLSClientConfig: withoutCert}}).Get(ts.URL)
+	assert.Error(t, err, "clients without a certificate are rejected")
+
+	withCert, err := ClientConfig(ca.CertPath, clientCert, clientKey)
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: withCert}}).Get(ts.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "agent-1", commonName)
+}
+
+func TestLoadCertPool_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, []byte("not a certificate"), 0o600))
+
+	_, err := LoadCertPool(path)
+	assert.ErrorIs(t, err, ErrNoCertificates)
+}
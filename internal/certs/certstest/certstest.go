@@ -0,0 +1,96 @@
+// Package certstest writes throwaway certificates for tests.
+package certstest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// CA signs certificates for localhost servers and named clients.
+type CA struct {
+	t    *testing.T
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// CertPath is the PEM file of the CA certificate.
+	CertPath string
+}
+
+// NewCA creates a CA with the given common name in a temporary directory.
+func NewCA(t *testing.T, name string) *CA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &CA{t: t, dir: t.TempDir(), cert: cert, key: key}
+	ca.CertPath = ca.write(name+"-ca.pem", "CERTIFICATE", der)
+	return ca
+}
+
+// Server issues a certificate for localhost and 127.0.0.1 and returns the
+// certificate and key files.
+func (ca *CA) Server(name string) (certPath, keyPath string) {
+	return ca.issue(name, x509.ExtKeyUsageServerAuth)
+}
+
+// Client issues a client certificate with the given common name and returns
+// the certificate and key files.
+func (ca *CA) Client(name string) (certPath, keyPath string) {
+	return ca.issue(name, x509.ExtKeyUsageClientAuth)
+}
+
+func (ca *CA) issue(name string, usage x509.ExtKeyUsage) (string, string) {
+	ca.t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(ca.t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(ca.t, err)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(ca.t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(ca.t, err)
+
+	return ca.write(name+".pem", "CERTIFICATE", der), ca.write(name+"-key.pem", "PRIVATE KEY", keyDER)
+}
+
+func (ca *CA) write(name, blockType string, der []byte) string {
+	ca.t.Helper()
+	path := filepath.Join(ca.dir, name)
+	require.NoError(ca.t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
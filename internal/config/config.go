@@ -1,67 +1,392 @@
+// Package config loads the agent and server configuration. Settings come
+// from an optional JSON or YAML file named by -c or CONFIG, environment
+// variables and command line flags, later sources overriding earlier ones.
 package config
 
 import (
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
 )
 
 type AgentConfig struct {
 	Address        string
 	ReportInterval time.Duration
 	PollInterval   time.Duration
+	Key            string
+	RateLimit      int
+	Labels         map[string]string
+	// AgentID identifies the agent to the server and defaults to the
+	// hostname. Tenant is the namespace its metrics are written to.
+	AgentID string
+	Tenant  string
+	// AllowMetrics and DenyMetrics are name patterns selecting the
+	// collected metrics to report, and RenameMetrics maps collected names
+	// to reported ones.
+	AllowMetrics  []string
+	DenyMetrics   []string
+	RenameMetrics map[string]string
+	// GRPCAddress makes the agent report over gRPC to this address instead
+	// of over HTTP.
+	GRPCAddress string
+	// CryptoKey is a PEM file with the server public key request bodies
+	// are encrypted with.
+	CryptoKey string
+	// TLSCA is the CA bundle the server certificate is checked against,
+	// and TLSCert and TLSKey the client certificate for mutual TLS. Setting
+	// any of them makes the agent connect with TLS.
+	TLSCA   string
+	TLSCert string
+	TLSKey  string
 }
 
 type ServerConfig struct {
-	Address string
+	Address         string
+	StoreInterval   time.Duration
+	FileStoragePath string
+	Restore         bool
+	DatabaseDSN     string
+	Key             string
+	// HistoryPoints and HistoryAge bound the history kept per series by the
+	// in-memory and file storages.
+	HistoryPoints int
+	HistoryAge    time.Duration
+	// AlertRulesPath is a YAML rules file checked every AlertInterval;
+	// alerts are posted to AlertWebhookURL when it is set.
+	AlertRulesPath  string
+	AlertInterval   time.Duration
+	AlertWebhookURL string
+	// GRPCAddress is where the gRPC ingestion service listens; empty
+	// disables it.
+	GRPCAddress string
+	// TrustedSubnet is a CIDR the X-Real-IP of every update must be in;
+	// empty accepts updates from anywhere.
+	TrustedSubnet string
+	// CryptoKey is a PEM file with the private key encrypted request
+	// bodies are decrypted with.
+	CryptoKey string
+	// TLSCert and TLSKey make the server listen with TLS; they are read
+	// again on SIGHUP. With TLSClientCA clients must present a certificate
+	// signed by it, and its common name identifies the agent.
+	TLSCert     string
+	TLSKey      string
+	TLSClientCA string
 }
 
-func LoadAgentConfig() *AgentConfig {
-	conf := &AgentConfig{}
-	var reportInterval, pollInterval int
+// ConfigEnv names the environment variable holding the config file path
+// when the -c flag is not given.
+const ConfigEnv = "CONFIG"
+
+func defaultAgentConfig() *AgentConfig {
+	return &AgentConfig{
+		Address:        "localhost:8080",
+		ReportInterval: 10 * time.Second,
+		PollInterval:   2 * time.Second,
+		RateLimit:      1,
+		AgentID:        defaultAgentID(),
+	}
+}
 
-	flag.StringVar(&conf.Address, "a", "localhost:8080", "address and port to run server")
-	flag.IntVar(&reportInterval, "r", 10, "report interval in seconds")
-	flag.IntVar(&pollInterval, "p", 2, "poll interval in seconds")
-	flag.Parse()
+func agentFlags(conf *AgentConfig, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	fs.StringVar(configPath, "c", "", "path to a JSON or YAML config file")
+	fs.StringVar(&conf.Address, "a", conf.Address, "address and port to run server")
+	fs.Var((*durationValue)(&conf.ReportInterval), "r", "report interval, in seconds or as a duration such as 10s")
+	fs.Var((*durationValue)(&conf.PollInterval), "p", "poll interval, in seconds or as a duration such as 2s")
+	fs.StringVar(&conf.Key, "k", conf.Key, "key used to sign request bodies with HMAC-SHA256")
+	fs.IntVar(&conf.RateLimit, "l", conf.RateLimit, "maximum number of concurrent outgoing requests")
+	fs.Var((*mapValue)(&conf.Labels), "labels", "labels attached to every metric, e.g. host=web1,service=api")
+	fs.StringVar(&conf.AgentID, "id", conf.AgentID, "agent id sent to the server, defaults to the hostname")
+	fs.StringVar(&conf.Tenant, "tenant", conf.Tenant, "tenant namespace the metrics are written to")
+	fs.Var((*listValue)(&conf.AllowMetrics), "allow", "comma separated metric name patterns to report, empty reports all")
+	fs.Var((*listValue)(&conf.DenyMetrics), "deny", "comma separated metric name patterns never reported")
+	fs.Var((*mapValue)(&conf.RenameMetrics), "rename", "metrics reported under another name, e.g. Alloc=heap_alloc,Frees=heap_frees")
+	fs.StringVar(&conf.GRPCAddress, "grpc-address", conf.GRPCAddress, "address of the server gRPC service, reports over HTTP when empty")
+	fs.StringVar(&conf.CryptoKey, "crypto-key", conf.CryptoKey, "path to the server public key in PEM used to encrypt request bodies")
+	fs.StringVar(&conf.TLSCA, "tls-ca", conf.TLSCA, "path to the CA bundle in PEM the server certificate is verified with")
+	fs.StringVar(&conf.TLSCert, "tls-cert", conf.TLSCert, "path to the client certificate in PEM for mutual TLS")
+	fs.StringVar(&conf.TLSKey, "tls-key", conf.TLSKey, "path to the client certificate key in PEM")
+	return fs
+}
 
-	conf.ReportInterval = time.Duration(reportInterval) * time.Second
-	conf.PollInterval = time.Duration(pollInterval) * time.Second
+func agentEnv(conf *AgentConfig) []error {
+	return []error{
+		envString("ADDRESS", &conf.Address),
+		envDuration("REPORT_INTERVAL", &conf.ReportInterval),
+		envDuration("POLL_INTERVAL", &conf.PollInterval),
+		envString("KEY", &conf.Key),
+		envInt("RATE_LIMIT", &conf.RateLimit),
+		envMap("LABELS", &conf.Labels),
+		envString("AGENT_ID", &conf.AgentID),
+		envString("TENANT", &conf.Tenant),
+		envList("METRICS_ALLOW", &conf.AllowMetrics),
+		envList("METRICS_DENY", &conf.DenyMetrics),
+		envMap("METRICS_RENAME", &conf.RenameMetrics),
+		envString("GRPC_ADDRESS", &conf.GRPCAddress),
+		envString("CRYPTO_KEY", &conf.CryptoKey),
+		envString("TLS_CA", &conf.TLSCA),
+		envString("TLS_CERT", &conf.TLSCert),
+		envString("TLS_KEY", &conf.TLSKey),
+	}
+}
 
-	if envAddr := os.Getenv("ADDRESS"); envAddr != "" {
-		conf.Address = envAddr
+// LoadAgentConfig reads the agent configuration from the config file, the
+// environment and args, in increasing order of precedence. All invalid
+// settings are reported together.
+func LoadAgentConfig(args []string) (*AgentConfig, error) {
+	path, err := configPath(agentFlags(defaultAgentConfig(), new(string)), args)
+	if err != nil {
+		return nil, err
 	}
 
-	if envReportInterval := os.Getenv("REPORT_INTERVAL"); envReportInterval != "" {
-		if v, err := strconv.Atoi(envReportInterval); err == nil {
-			conf.ReportInterval = time.Duration(v) * time.Second
-		} else {
-			log.Printf("invalid REPORT_INTERVAL: %v, using default", err)
+	conf := defaultAgentConfig()
+	if path != "" {
+		var f agentFile
+		if err := readFile(path, &f); err != nil {
+			return nil, err
 		}
+		f.apply(conf)
+	}
+	errs := agentEnv(conf)
+	// The flags were already checked above, parsing them again over the
+	// file and environment only overrides the ones actually given.
+	agentFlags(conf, new(string)).Parse(args)
+
+	return conf, errors.Join(append(errs, conf.Validate())...)
+}
+
+// Validate reports every invalid setting of the agent configuration.
+func (c *AgentConfig) Validate() error {
+	return errors.Join(
+		validateAddress("address", c.Address, true),
+		validateAddress("gRPC address", c.GRPCAddress, false),
+		positive("report interval", c.ReportInterval),
+		positive("poll interval", c.PollInterval),
+		atLeast("rate limit", c.RateLimit, 1),
+		validatePair("client certificate", c.TLSCert, c.TLSKey),
+		validateLabels(c.Labels),
+	)
+}
+
+func validateLabels(labels map[string]string) error {
+	if err := storage.Labels(labels).Validate(); err != nil {
+		return fmt.Errorf("invalid labels: %w", err)
 	}
+	return nil
+}
 
-	if envPollInterval := os.Getenv("POLL_INTERVAL"); envPollInterval != "" {
-		if v, err := strconv.Atoi(envPollInterval); err == nil {
-			conf.PollInterval = time.Duration(v) * time.Second
-		} else {
-			log.Printf("invalid POLL_INTERVAL: %v, using default", err)
+func defaultAgentID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return hostname
+}
+
+func defaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Address:         "localhost:8080",
+		StoreInterval:   300 * time.Second,
+		FileStoragePath: "/tmp/metrics-db.json",
+		Restore:         true,
+		HistoryPoints:   720,
+		HistoryAge:      time.Hour,
+		AlertInterval:   10 * time.Second,
+	}
+}
+
+func serverFlags(conf *ServerConfig, configPath *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	fs.StringVar(configPath, "c", "", "path to a JSON or YAML config file")
+	fs.StringVar(&conf.Address, "a", conf.Address, "address and port to run server")
+	fs.Var((*durationValue)(&conf.StoreInterval), "i", "interval between metric dumps to file, in seconds or as a duration, 0 makes writes synchronous")
+	fs.StringVar(&conf.FileStoragePath, "f", conf.FileStoragePath, "path to the metrics dump file, empty disables file storage")
+	fs.BoolVar(&conf.Restore, "r", conf.Restore, "restore metrics from the dump file on start")
+	fs.StringVar(&conf.DatabaseDSN, "d", conf.DatabaseDSN, "PostgreSQL DSN, takes precedence over file storage")
+	fs.StringVar(&conf.Key, "k", conf.Key, "key used to verify request signatures and sign responses")
+	fs.IntVar(&conf.HistoryPoints, "history-points", conf.HistoryPoints, "points of history kept per series, 0 disables history")
+	fs.Var((*durationValue)(&conf.HistoryAge), "history-age", "age after which history points are dropped, in seconds or as a duration, 0 keeps them")
+	fs.StringVar(&conf.AlertRulesPath, "alert-rules", conf.AlertRulesPath, "path to a YAML file with alerting rules")
+	fs.Var((*durationValue)(&conf.AlertInterval), "alert-interval", "interval between alerting rule evaluations, in seconds or as a duration")
+	fs.StringVar(&conf.AlertWebhookURL, "alert-webhook", conf.AlertWebhookURL, "URL alerts are posted to when they fire or resolve")
+	fs.StringVar(&conf.GRPCAddress, "grpc-address", conf.GRPCAddress, "address and port to run the gRPC service, empty disables it")
+	fs.StringVar(&conf.TrustedSubnet, "t", conf.TrustedSubnet, "CIDR agents must report from, empty accepts any address")
+	fs.StringVar(&conf.CryptoKey, "crypto-key", conf.CryptoKey, "path to the private key in PEM used to decrypt request bodies")
+	fs.StringVar(&conf.TLSCert, "tls-cert", conf.TLSCert, "path to the server certificate in PEM, enables TLS")
+	fs.StringVar(&conf.TLSKey, "tls-key", conf.TLSKey, "path to the server certificate key in PEM")
+	fs.StringVar(&conf.TLSClientCA, "tls-client-ca", conf.TLSClientCA, "path to the CA bundle in PEM client certificates must be signed by")
+	return fs
+}
+
+func serverEnv(conf *ServerConfig) []error {
+	if envPath, ok := os.LookupEnv("FILE_STORAGE_PATH"); ok {
+		conf.FileStoragePath = envPath
+	}
+	return []error{
+		envString("ADDRESS", &conf.Address),
+		envDuration("STORE_INTERVAL", &conf.StoreInterval),
+		envBool("RESTORE", &conf.Restore),
+		envString("DATABASE_DSN", &conf.DatabaseDSN),
+		envString("KEY", &conf.Key),
+		envInt("HISTORY_POINTS", &conf.HistoryPoints),
+		envDuration("HISTORY_AGE", &conf.HistoryAge),
+		envString("ALERT_RULES", &conf.AlertRulesPath),
+		envDuration("ALERT_INTERVAL", &conf.AlertInterval),
+		envString("ALERT_WEBHOOK", &conf.AlertWebhookURL),
+		envString("GRPC_ADDRESS", &conf.GRPCAddress),
+		envString("TRUSTED_SUBNET", &conf.TrustedSubnet),
+		envString("CRYPTO_KEY", &conf.CryptoKey),
+		envString("TLS_CERT", &conf.TLSCert),
+		envString("TLS_KEY", &conf.TLSKey),
+		envString("TLS_CLIENT_CA", &conf.TLSClientCA),
+	}
+}
+
+// LoadServerConfig reads the server configuration from the config file,
+// the environment and args, in increasing order of precedence. All invalid
+// settings are reported together.
+func LoadServerConfig(args []string) (*ServerConfig, error) {
+	path, err := configPath(serverFlags(defaultServerConfig(), new(string)), args)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := defaultServerConfig()
+	if path != "" {
+		var f serverFile
+		if err := readFile(path, &f); err != nil {
+			return nil, err
 		}
+		f.apply(conf)
 	}
+	errs := serverEnv(conf)
+	serverFlags(conf, new(string)).Parse(args)
 
-	return conf
+	return conf, errors.Join(append(errs, conf.Validate())...)
 }
 
-func LoadServerConfig() *ServerConfig {
-	conf := &ServerConfig{}
+// Validate reports every invalid setting of the server configuration.
+func (c *ServerConfig) Validate() error {
+	var subnetErr error
+	if c.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(c.TrustedSubnet); err != nil {
+			subnetErr = fmt.Errorf("invalid trusted subnet: %w", err)
+		}
+	}
+	return errors.Join(
+		validateAddress("address", c.Address, true),
+		validateAddress("gRPC address", c.GRPCAddress, false),
+		notNegative("store interval", c.StoreInterval),
+		atLeast("history points", c.HistoryPoints, 0),
+		notNegative("history age", c.HistoryAge),
+		positive("alert interval", c.AlertInterval),
+		subnetErr,
+		validatePair("server certificate", c.TLSCert, c.TLSKey),
+	)
+}
 
-	flag.StringVar(&conf.Address, "a", "localhost:8080", "address and port to run server")
-	flag.Parse()
+// configPath parses args with fs, which must define -c, and returns the
+// config file named by it or by the environment.
+func configPath(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	if f := fs.Lookup("c"); f.Value.String() != "" {
+		return f.Value.String(), nil
+	}
+	return os.Getenv(ConfigEnv), nil
+}
+
+func validateAddress(name, addr string, required bool) error {
+	if addr == "" {
+		if required {
+			return fmt.Errorf("%s is not set", name)
+		}
+		return nil
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %w", name, addr, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid %s %q: bad port %q", name, addr, port)
+	}
+	return nil
+}
+
+func positive(name string, d time.Duration) error {
+	if d <= 0 {
+		return fmt.Errorf("%s must be positive, got %s", name, d)
+	}
+	return nil
+}
+
+func notNegative(name string, d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("%s must not be negative, got %s", name, d)
+	}
+	return nil
+}
 
-	if envAddr := os.Getenv("ADDRESS"); envAddr != "" {
-		conf.Address = envAddr
+func atLeast(name string, v, min int) error {
+	if v < min {
+		return fmt.Errorf("%s must be at least %d, got %d", name, min, v)
 	}
+	return nil
+}
+
+// validatePair checks that a certificate and its key are set together.
+func validatePair(name, cert, key string) error {
+	if (cert == "") != (key == "") {
+		return fmt.Errorf("%s and its key must be set together", name)
+	}
+	return nil
+}
+
+// parseDuration accepts a duration such as 10s or 1m30s, or a bare
+// integer number of seconds.
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q, want seconds or a value such as 10s", s)
+	}
+	return d, nil
+}
 
-	return conf
+// parseList splits a comma separated list, dropping empty items.
+func parseList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// parseLabels parses a comma separated list of name=value pairs.
+func parseLabels(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("malformed label %q, want name=value", pair)
+		}
+		labels[name] = value
+	}
+	return labels, nil
 }
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadAgentConfig_Defaults(t *testing.T) {
+	conf, err := LoadAgentConfig(nil)
+	require.NoError(t, err)
+	assert.Equal(t, "localhost:8080", conf.Address)
+	assert.Equal(t, 10*time.Second, conf.ReportInterval)
+	assert.Equal(t, 2*time.Second, conf.PollInterval)
+	assert.Equal(t, 1, conf.RateLimit)
+}
+
+func TestLoadAgentConfig_Precedence(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "agent.yaml",
+			content: `
+address: file:1
+report_interval: 30s
+poll_interval: 5
+rate_limit: 4
+labels: {host: web1}
+metrics_deny: [Frees]
+`,
+		},
+		{
+			name:    "json",
+			file:    "agent.json",
+			content: `{"address": "file:1", "report_interval": "30s", "poll_interval": 5, "rate_limit": 4, "labels": {"host": "web1"}, "metrics_deny": ["Frees"]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.file, tt.content)
+			t.Setenv("ADDRESS", "env:2")
+			t.Setenv("REPORT_INTERVAL", "20")
+			t.Setenv("RATE_LIMIT", "3")
+
+			conf, err := LoadAgentConfig([]string{"-c", path, "-a", "flag:3", "-r", "1m"})
+			require.NoError(t, err)
+			assert.Equal(t, "flag:3", conf.Address, "flags override the environment")
+			assert.Equal(t, time.Minute, conf.ReportInterval, "flags override the environment")
+			assert.Equal(t, 3, conf.RateLimit, "the environment overrides the file")
+			assert.Equal(t, 5*time.Second, conf.PollInterval, "integer durations are seconds")
+			assert.Equal(t, map[string]string{"host": "web1"}, conf.Labels)
+			assert.Equal(t, []string{"Frees"}, conf.DenyMetrics)
+		})
+	}
+}
+
+func TestLoadServerConfig_ConfigEnv(t *testing.T) {
+	path := writeConfig(t, "server.yml", "store_interval: 1m\nrestore: false\nhistory_age: 7200\n")
+	t.Setenv(ConfigEnv, path)
+	t.Setenv("STORE_INTERVAL", "10s")
+
+	conf, err := LoadServerConfig([]string{"-history-points", "10"})
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, conf.StoreInterval)
+	assert.False(t, conf.Restore)
+	assert.Equal(t, 2*time.Hour, conf.HistoryAge)
+	assert.Equal(t, 10, conf.HistoryPoints)
+	assert.Equal(t, "/tmp/metrics-db.json", conf.FileStoragePath)
+}
+
+func TestLoadServerConfig_FlagOverridesConfigEnv(t *testing.T) {
+	t.Setenv(ConfigEnv, filepath.Join(t.TempDir(), "missing.yaml"))
+	path := writeConfig(t, "server.yaml", "address: :9090\n")
+
+	conf, err := LoadServerConfig([]string{"-c", path})
+	require.NoError(t, err)
+	assert.Equal(t, ":9090", conf.Address)
+}
+
+func TestLoadAgentConfig_AggregatedErrors(t *testing.T) {
+	path := writeConfig(t, "agent.yaml", "address: no-port\npoll_interval: -1s\n")
+	t.Setenv("RATE_LIMIT", "many")
+
+	_, err := LoadAgentConfig([]string{"-c", path, "-r", "0"})
+	require.Error(t, err)
+	for _, want := range []string{"invalid RATE_LIMIT", "invalid address", "report interval must be positive", "poll interval must be positive"} {
+		assert.ErrorContains(t, err, want)
+	}
+}
+
+func TestLoadAgentConfig_InvalidLabels(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  string
+		args []string
+	}{
+		{name: "flag", args: []string{"-labels", "bad-name=x"}},
+		{name: "environment", env: "0host=web1"},
+		{name: "config file", file: "labels: {\"host name\": web1}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-c", writeConfig(t, "agent.yaml", tt.file)}, args...)
+			}
+			if tt.env != "" {
+				t.Setenv("LABELS", tt.env)
+			}
+			_, err := LoadAgentConfig(args)
+			assert.ErrorIs(t, err, storage.ErrInvalidLabel)
+		})
+	}
+}
+
+func TestLoadServerConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		args    []string
+		want    string
+	}{
+		{name: "unknown key", file: "server.yaml", content: "adress: :8080\n", want: "adress"},
+		{name: "unknown json key", file: "server.json", content: `{"adress": ":8080"}`, want: "adress"},
+		{name: "bad duration", file: "server.yaml", content: "store_interval: soon\n", want: "invalid duration"},
+		{name: "missing file", file: "", args: []string{"-c", "/nonexistent/server.yaml"}, want: "failed to read config file"},
+		{name: "bad flag", args: []string{"-i", "soon"}, want: "invalid duration"},
+		{name: "negative history", args: []string{"-history-age", "-1m", "-history-points", "-1"}, want: "history points must be at least 0"},
+		{name: "trusted subnet", args: []string{"-t", "10.0.0.0"}, want: "invalid trusted subnet"},
+		{name: "certificate without key", args: []string{"-tls-cert", "cert.pem"}, want: "server certificate and its key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-c", writeConfig(t, tt.file, tt.content)}, args...)
+			}
+			_, err := LoadServerConfig(args)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
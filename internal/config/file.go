@@ -0,0 +1,140 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config file keys are the names of the matching environment variables in
+// lower case. Settings missing from the file keep their defaults.
+
+type agentFile struct {
+	Address        *string           `json:"address" yaml:"address"`
+	ReportInterval *durationValue    `json:"report_interval" yaml:"report_interval"`
+	PollInterval   *durationValue    `json:"poll_interval" yaml:"poll_interval"`
+	Key            *string           `json:"key" yaml:"key"`
+	RateLimit      *int              `json:"rate_limit" yaml:"rate_limit"`
+	Labels         map[string]string `json:"labels" yaml:"labels"`
+	AgentID        *string           `json:"agent_id" yaml:"agent_id"`
+	Tenant         *string           `json:"tenant" yaml:"tenant"`
+	AllowMetrics   []string          `json:"metrics_allow" yaml:"metrics_allow"`
+	DenyMetrics    []string          `json:"metrics_deny" yaml:"metrics_deny"`
+	RenameMetrics  map[string]string `json:"metrics_rename" yaml:"metrics_rename"`
+	GRPCAddress    *string           `json:"grpc_address" yaml:"grpc_address"`
+	CryptoKey      *string           `json:"crypto_key" yaml:"crypto_key"`
+	TLSCA          *string           `json:"tls_ca" yaml:"tls_ca"`
+	TLSCert        *string           `json:"tls_cert" yaml:"tls_cert"`
+	TLSKey         *string           `json:"tls_key" yaml:"tls_key"`
+}
+
+func (f *agentFile) apply(conf *AgentConfig) {
+	set(&conf.Address, f.Address)
+	setDuration(&conf.ReportInterval, f.ReportInterval)
+	setDuration(&conf.PollInterval, f.PollInterval)
+	set(&conf.Key, f.Key)
+	set(&conf.RateLimit, f.RateLimit)
+	if f.Labels != nil {
+		conf.Labels = f.Labels
+	}
+	set(&conf.AgentID, f.AgentID)
+	set(&conf.Tenant, f.Tenant)
+	if f.AllowMetrics != nil {
+		conf.AllowMetrics = f.AllowMetrics
+	}
+	if f.DenyMetrics != nil {
+		conf.DenyMetrics = f.DenyMetrics
+	}
+	if f.RenameMetrics != nil {
+		conf.RenameMetrics = f.RenameMetrics
+	}
+	set(&conf.GRPCAddress, f.GRPCAddress)
+	set(&conf.CryptoKey, f.CryptoKey)
+	set(&conf.TLSCA, f.TLSCA)
+	set(&conf.TLSCert, f.TLSCert)
+	set(&conf.TLSKey, f.TLSKey)
+}
+
+type serverFile struct {
+	Address         *string        `json:"address" yaml:"address"`
+	StoreInterval   *durationValue `json:"store_interval" yaml:"store_interval"`
+	FileStoragePath *string        `json:"file_storage_path" yaml:"file_storage_path"`
+	Restore         *bool          `json:"restore" yaml:"restore"`
+	DatabaseDSN     *string        `json:"database_dsn" yaml:"database_dsn"`
+	Key             *string        `json:"key" yaml:"key"`
+	HistoryPoints   *int           `json:"history_points" yaml:"history_points"`
+	HistoryAge      *durationValue `json:"history_age" yaml:"history_age"`
+	AlertRulesPath  *string        `json:"alert_rules" yaml:"alert_rules"`
+	AlertInterval   *durationValue `json:"alert_interval" yaml:"alert_interval"`
+	AlertWebhookURL *string        `json:"alert_webhook" yaml:"alert_webhook"`
+	GRPCAddress     *string        `json:"grpc_address" yaml:"grpc_address"`
+	TrustedSubnet   *string        `json:"trusted_subnet" yaml:"trusted_subnet"`
+	CryptoKey       *string        `json:"crypto_key" yaml:"crypto_key"`
+	TLSCert         *string        `json:"tls_cert" yaml:"tls_cert"`
+	TLSKey          *string        `json:"tls_key" yaml:"tls_key"`
+	TLSClientCA     *string        `json:"tls_client_ca" yaml:"tls_client_ca"`
+}
+
+func (f *serverFile) apply(conf *ServerConfig) {
+	set(&conf.Address, f.Address)
+	setDuration(&conf.StoreInterval, f.StoreInterval)
+	set(&conf.FileStoragePath, f.FileStoragePath)
+	set(&conf.Restore, f.Restore)
+	set(&conf.DatabaseDSN, f.DatabaseDSN)
+	set(&conf.Key, f.Key)
+	set(&conf.HistoryPoints, f.HistoryPoints)
+	setDuration(&conf.HistoryAge, f.HistoryAge)
+	set(&conf.AlertRulesPath, f.AlertRulesPath)
+	setDuration(&conf.AlertInterval, f.AlertInterval)
+	set(&conf.AlertWebhookURL, f.AlertWebhookURL)
+	set(&conf.GRPCAddress, f.GRPCAddress)
+	set(&conf.TrustedSubnet, f.TrustedSubnet)
+	set(&conf.CryptoKey, f.CryptoKey)
+	set(&conf.TLSCert, f.TLSCert)
+	set(&conf.TLSKey, f.TLSKey)
+	set(&conf.TLSClientCA, f.TLSClientCA)
+}
+
+func set[T any](dst *T, v *T) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func setDuration(dst *time.Duration, v *durationValue) {
+	if v != nil {
+		*dst = time.Duration(*v)
+	}
+}
+
+// readFile decodes the config file at path into f, as JSON when its
+// extension is .json and as YAML otherwise. Unknown keys are rejected.
+func readFile(path string, f any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(f)
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err = dec.Decode(f); err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
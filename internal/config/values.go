@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// durationValue is a flag and config file value accepting a duration such
+// as 10s or an integer number of seconds.
+type durationValue time.Duration
+
+func (d *durationValue) String() string { return time.Duration(*d).String() }
+
+func (d *durationValue) Set(s string) error {
+	v, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+func (d *durationValue) UnmarshalJSON(data []byte) error {
+	return d.Set(strings.Trim(string(data), `"`))
+}
+
+func (d *durationValue) UnmarshalYAML(value *yaml.Node) error {
+	return d.Set(value.Value)
+}
+
+// listValue is a flag holding a comma separated list.
+type listValue []string
+
+func (l *listValue) String() string { return strings.Join(*l, ",") }
+
+func (l *listValue) Set(s string) error {
+	*l = parseList(s)
+	return nil
+}
+
+// mapValue is a flag holding comma separated name=value pairs.
+type mapValue map[string]string
+
+func (m *mapValue) String() string {
+	pairs := make([]string, 0, len(*m))
+	for name, value := range *m {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *mapValue) Set(s string) error {
+	v, err := parseLabels(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// The env helpers below override dst with the variable when it is set and
+// return an error naming the variable when its value is malformed.
+
+func envString(name string, dst *string) error {
+	if v := os.Getenv(name); v != "" {
+		*dst = v
+	}
+	return nil
+}
+
+func envDuration(name string, dst *time.Duration) error {
+	return envParse(name, (*durationValue)(dst).Set)
+}
+
+func envInt(name string, dst *int) error {
+	return envParse(name, func(s string) error {
+		v, err := strconv.Atoi(s)
+		if err == nil {
+			*dst = v
+		}
+		return err
+	})
+}
+
+func envBool(name string, dst *bool) error {
+	return envParse(name, func(s string) error {
+		v, err := strconv.ParseBool(s)
+		if err == nil {
+			*dst = v
+		}
+		return err
+	})
+}
+
+func envList(name string, dst *[]string) error {
+	return envParse(name, (*listValue)(dst).Set)
+}
+
+func envMap(name string, dst *map[string]string) error {
+	return envParse(name, (*mapValue)(dst).Set)
+}
+
+func envParse(name string, set func(string) error) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	if err := set(v); err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// Package encryption seals request bodies for the server with hybrid
+// encryption: a fresh AES-256-GCM key encrypts the body and RSA-OAEP with
+// SHA-256 encrypts that key, so bodies of any size can be sent.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// Header marks an encrypted request body and names its scheme.
+	Header = "X-Encryption"
+	// Scheme is the only scheme supported.
+	Scheme = "rsa-oaep-aes256gcm"
+)
+
+const aesKeySize = 32
+
+var ErrMalformed = errors.New("malformed encrypted message")
+
+// Encrypt returns the RSA encrypted AES key, followed by the GCM nonce and
+// the sealed plaintext.
+func Encrypt(pub *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	key := make([]byte, aesKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt key: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, len(encryptedKey)+len(nonce)+len(plaintext)+gcm.Overhead())
+	result = append(result, encryptedKey...)
+	result = append(result, nonce...)
+	return gcm.Seal(result, nonce, plaintext, nil), nil
+}
+
+// Decrypt reverses Encrypt.
+func Decrypt(priv *rsa.PrivateKey, message []byte) ([]byte, error) {
+	keySize := priv.Size()
+	if len(message) < keySize {
+		return nil, ErrMalformed
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), nil, priv, message[:keySize], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	rest := message[keySize:]
+	if len(rest) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	plaintext, err := gcm.Open(nil, rest[:gcm.NonceSize()], rest[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt body: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != aesKeySize {
+		return nil, ErrMalformed
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// LoadPublicKey reads an RSA public key from a PEM file holding a PKIX or
+// PKCS #1 public key or a certificate.
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA public key", path)
+	}
+	return pub, nil
+}
+
+// LoadPrivateKey reads an RSA private key from a PEM file in PKCS #1 or
+// PKCS #8 form.
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unexpected PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA private key", path)
+	}
+	return priv, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+	return block, nil
+}
@@ -0,0 +1,112 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "key.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := generateKey(t)
+
+	tests := []struct {
+		name      string
+		plaintext []byte
+	}{
+		{name: "empty", plaintext: []byte{}},
+		{name: "small", plaintext: []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)},
+		{name: "larger than the rsa key", plaintext: bytes.Repeat([]byte("metrics"), 10000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := Encrypt(&key.PublicKey, tt.plaintext)
+			require.NoError(t, err)
+
+			plaintext, err := Decrypt(key, message)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(tt.plaintext, plaintext))
+		})
+	}
+}
+
+func TestDecrypt_Rejects(t *testing.T) {
+	key := generateKey(t)
+	message, err := Encrypt(&key.PublicKey, []byte("payload"))
+	require.NoError(t, err)
+
+	tampered := bytes.Clone(message)
+	tampered[len(tampered)-1] ^= 1
+	_, err = Decrypt(key, tampered)
+	assert.Error(t, err, "tampered body")
+
+	_, err = Decrypt(generateKey(t), message)
+	assert.Error(t, err, "wrong key")
+
+	_, err = Decrypt(key, message[:10])
+	assert.ErrorIs(t, err, ErrMalformed, "truncated message")
+}
+
+func TestLoadKeys(t *testing.T) {
+	key := generateKey(t)
+
+	pkix8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	for name, path := range map[string]string{
+		"pkcs1": writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)),
+		"pkcs8": writePEM(t, "PRIVATE KEY", pkix8),
+	} {
+		loaded, err := LoadPrivateKey(path)
+		require.NoError(t, err, name)
+		assert.True(t, key.Equal(loaded), name)
+	}
+
+	pkixPub, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "metrics"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	for name, path := range map[string]string{
+		"pkix":        writePEM(t, "PUBLIC KEY", pkixPub),
+		"pkcs1":       writePEM(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&key.PublicKey)),
+		"certificate": writePEM(t, "CERTIFICATE", cert),
+	} {
+		loaded, err := LoadPublicKey(path)
+		require.NoError(t, err, name)
+		assert.True(t, key.PublicKey.Equal(loaded), name)
+	}
+
+	_, err = LoadPublicKey(writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key)))
+	assert.Error(t, err, "private key where a public one is expected")
+	_, err = LoadPrivateKey(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+}
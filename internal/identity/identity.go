@@ -0,0 +1,48 @@
+package identity
+
+import (
+	"context"
+	"crypto/tls"
+)
+
+const (
+	// AgentHeader carries the id of the agent that produced the metrics,
+	// usually its hostname.
+	AgentHeader = "X-Agent-ID"
+	// TenantHeader selects the namespace metrics are written to and read
+	// from. Requests without it use the default, empty, tenant.
+	TenantHeader = "X-Tenant-ID"
+	// RealIPHeader carries the address the agent reaches the server from,
+	// checked against the trusted subnet.
+	RealIPHeader = "X-Real-IP"
+)
+
+// Identity describes who sent a request.
+type Identity struct {
+	Source string
+	Tenant string
+	// Verified is set when Source is the common name of a verified client
+	// certificate rather than a header the client chose.
+	Verified bool
+}
+
+type contextKey struct{}
+
+func NewContext(ctx context.Context, id Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the identity stored in ctx, or a zero Identity.
+func FromContext(ctx context.Context) Identity {
+	id, _ := ctx.Value(contextKey{}).(Identity)
+	return id
+}
+
+// CommonName returns the common name of the verified client certificate of
+// a TLS connection, or an empty string when the client was not verified.
+func CommonName(state *tls.ConnectionState) string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
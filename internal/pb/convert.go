@@ -0,0 +1,85 @@
+// Package pb holds the protobuf messages and gRPC service of the metrics
+// API, generated from api/proto/metrics.proto, and their conversion to
+// storage metrics.
+package pb
+
+//go:generate protoc -I ../../api/proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative metrics.proto
+
+import "github.com/yokitheyo/guardian-metrics/internal/storage"
+
+var (
+	toStorageType = map[MetricType]storage.MetricType{
+		MetricType_METRIC_TYPE_GAUGE:     storage.Gauge,
+		MetricType_METRIC_TYPE_COUNTER:   storage.Counter,
+		MetricType_METRIC_TYPE_HISTOGRAM: storage.Histogram,
+	}
+	fromStorageType = map[storage.MetricType]MetricType{
+		storage.Gauge:     MetricType_METRIC_TYPE_GAUGE,
+		storage.Counter:   MetricType_METRIC_TYPE_COUNTER,
+		storage.Histogram: MetricType_METRIC_TYPE_HISTOGRAM,
+	}
+)
+
+// FromMetric returns the wire form of m.
+func FromMetric(m storage.Metric) *Metric {
+	result := &Metric{
+		Id:     m.ID,
+		Type:   fromStorageType[m.MType],
+		Labels: m.Labels,
+		Source: m.Source,
+		Tenant: m.Tenant,
+	}
+	switch {
+	case m.Value != nil:
+		result.Data = &Metric_Value{Value: *m.Value}
+	case m.Delta != nil:
+		result.Data = &Metric_Delta{Delta: *m.Delta}
+	case m.Histogram != nil:
+		result.Data = &Metric_Histogram{Histogram: &Histogram{
+			Buckets: m.Histogram.Buckets,
+			Counts:  m.Histogram.Counts,
+			Count:   m.Histogram.Count,
+			Sum:     m.Histogram.Sum,
+		}}
+	}
+	return result
+}
+
+// ToMetric returns the storage form of m. An unknown type becomes an empty
+// one, which fails storage.Metric.Validate.
+func (m *Metric) ToMetric() storage.Metric {
+	result := storage.Metric{
+		ID:     m.GetId(),
+		MType:  toStorageType[m.GetType()],
+		Source: m.GetSource(),
+		Tenant: m.GetTenant(),
+	}
+	if len(m.GetLabels()) > 0 {
+		result.Labels = m.GetLabels()
+	}
+	switch data := m.GetData().(type) {
+	case *Metric_Value:
+		v := data.Value
+		result.Value = &v
+	case *Metric_Delta:
+		d := data.Delta
+		result.Delta = &d
+	case *Metric_Histogram:
+		result.Histogram = &storage.HistogramValue{
+			Buckets: data.Histogram.GetBuckets(),
+			Counts:  data.Histogram.GetCounts(),
+			Count:   data.Histogram.GetCount(),
+			Sum:     data.Histogram.GetSum(),
+		}
+	}
+	return result
+}
+
+// FromMetrics returns the wire form of a batch.
+func FromMetrics(metrics []storage.Metric) []*Metric {
+	result := make([]*Metric, len(metrics))
+	for i, m := range metrics {
+		result[i] = FromMetric(m)
+	}
+	return result
+}
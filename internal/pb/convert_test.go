@@ -0,0 +1,61 @@
+package pb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestConvert_RoundTrip(t *testing.T) {
+	h := storage.NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+
+	gauge := storage.NewGauge("Alloc", 1.5)
+	gauge.Labels = storage.Labels{"host": "web1"}
+	gauge.Source = "host-1"
+	gauge.Tenant = "team-a"
+
+	tests := []struct {
+		name string
+		m    storage.Metric
+	}{
+		{name: "gauge", m: gauge},
+		{name: "counter", m: storage.NewCounter("PollCount", 7)},
+		{name: "histogram", m: storage.Metric{ID: "Latency", MType: storage.Histogram, Histogram: h}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := proto.Marshal(FromMetric(tt.m))
+			assert.NoError(t, err)
+			var decoded Metric
+			assert.NoError(t, proto.Unmarshal(data, &decoded))
+			assert.Equal(t, tt.m, decoded.ToMetric())
+		})
+	}
+}
+
+func TestConvert_UnknownType(t *testing.T) {
+	m := (&Metric{Id: "Alloc", Data: &Metric_Value{Value: 1}}).ToMetric()
+	assert.ErrorIs(t, m.Validate(), storage.ErrInvalidType)
+}
+
+func TestSignRequest(t *testing.T) {
+	req := &UpdateMetricsRequest{Metrics: FromMetrics([]storage.Metric{storage.NewGauge("Alloc", 1)})}
+	unsigned, err := MarshalSigned(req)
+	assert.NoError(t, err)
+
+	assert.NoError(t, SignRequest(req, "secret"))
+	assert.NotEmpty(t, req.GetSignature())
+	assert.True(t, VerifyRequest(req, "secret"))
+	assert.False(t, VerifyRequest(req, "other"))
+
+	signed, err := MarshalSigned(req)
+	assert.NoError(t, err)
+	assert.Equal(t, unsigned, signed, "the signature is not part of the signed bytes")
+
+	req.Metrics[0].Id = "Frees"
+	assert.False(t, VerifyRequest(req, "secret"), "tampered message")
+}
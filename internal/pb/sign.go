@@ -0,0 +1,34 @@
+package pb
+
+import (
+	"github.com/yokitheyo/guardian-metrics/internal/sign"
+	"google.golang.org/protobuf/proto"
+)
+
+// MarshalSigned returns the bytes the HashSHA256 signature of a call
+// covers. Marshaling is deterministic so that client and server agree on
+// them, and leaves out the signature field of a request.
+func MarshalSigned(msg proto.Message) ([]byte, error) {
+	if req, ok := msg.(*UpdateMetricsRequest); ok && req.GetSignature() != "" {
+		unsigned := proto.Clone(req).(*UpdateMetricsRequest)
+		unsigned.Signature = ""
+		msg = unsigned
+	}
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
+
+// SignRequest sets the signature of a request sent on a stream.
+func SignRequest(req *UpdateMetricsRequest, key string) error {
+	data, err := MarshalSigned(req)
+	if err != nil {
+		return err
+	}
+	req.Signature = sign.Sum(data, key)
+	return nil
+}
+
+// VerifyRequest reports whether req carries a valid signature for key.
+func VerifyRequest(req *UpdateMetricsRequest, key string) bool {
+	data, err := MarshalSigned(req)
+	return err == nil && sign.Verify(data, key, req.GetSignature())
+}
@@ -0,0 +1,72 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+)
+
+// DefaultDelays is the pause before each repeated attempt: three retries
+// after 1s, 3s and 5s.
+var DefaultDelays = []time.Duration{time.Second, 3 * time.Second, 5 * time.Second}
+
+type retriableError struct {
+	err error
+}
+
+func (e *retriableError) Error() string { return e.err.Error() }
+func (e *retriableError) Unwrap() error { return e.err }
+
+// Retriable marks err as transient so IsRetriable reports true for it and
+// for any error wrapping it.
+func Retriable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retriableError{err: err}
+}
+
+// IsRetriable reports whether err is worth another attempt: errors marked
+// with Retriable, refused or reset connections and network timeouts.
+func IsRetriable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var re *retriableError
+	if errors.As(err, &re) {
+		return true
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Do calls fn and, while it fails with an error accepted by isRetriable,
+// calls it again after each of delays in turn. It stops early when ctx is
+// done and returns the last error from fn.
+func Do(ctx context.Context, delays []time.Duration, isRetriable func(error) bool, fn func() error) error {
+	err := fn()
+	for _, delay := range delays {
+		if err == nil || !isRetriable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		err = fn()
+	}
+	return err
+}
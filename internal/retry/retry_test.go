@@ -0,0 +1,85 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testDelays = []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+
+func TestDo_SucceedsAfterRetries(t *testing.T) {
+	calls := 0
+	err := Do(context.Background(), testDelays, IsRetriable, func() error {
+		calls++
+		if calls < 3 {
+			return syscall.ECONNREFUSED
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestDo_GivesUpAfterSchedule(t *testing.T) {
+	calls := 0
+	err := Do(context.Background(), testDelays, IsRetriable, func() error {
+		calls++
+		return Retriable(errors.New("server error"))
+	})
+
+	assert.Error(t, err)
+	assert.Equal(t, len(testDelays)+1, calls)
+}
+
+func TestDo_DoesNotRetryPermanentErrors(t *testing.T) {
+	calls := 0
+	permanent := errors.New("bad request")
+	err := Do(context.Background(), testDelays, IsRetriable, func() error {
+		calls++
+		return permanent
+	})
+
+	assert.ErrorIs(t, err, permanent)
+	assert.Equal(t, 1, calls)
+}
+
+func TestDo_StopsOnContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := Do(ctx, []time.Duration{time.Hour}, IsRetriable, func() error {
+		calls++
+		cancel()
+		return syscall.ECONNREFUSED
+	})
+
+	assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+	assert.Equal(t, 1, calls)
+}
+
+func TestIsRetriable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain error", err: errors.New("boom"), want: false},
+		{name: "marked", err: Retriable(errors.New("boom")), want: true},
+		{name: "wrapped marked", err: fmt.Errorf("send: %w", Retriable(errors.New("boom"))), want: true},
+		{name: "connection refused", err: fmt.Errorf("dial: %w", syscall.ECONNREFUSED), want: true},
+		{name: "connection reset", err: syscall.ECONNRESET, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsRetriable(tt.err))
+		})
+	}
+}
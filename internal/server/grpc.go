@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/yokitheyo/guardian-metrics/internal/config"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"github.com/yokitheyo/guardian-metrics/internal/pb"
+	"github.com/yokitheyo/guardian-metrics/internal/server/interceptor"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// metricsService implements the gRPC MetricsService on top of the same
+// storage as the HTTP API.
+type metricsService struct {
+	pb.UnimplementedMetricsServiceServer
+	storage storage.Storage
+	// key, when set, checks the signature of every streamed message.
+	key string
+}
+
+// NewGRPCServer serves the MetricsService with the same checks as the HTTP
+// update routes, including the trusted subnet when it is not nil. Extra
+// options such as transport credentials are passed on to grpc.NewServer.
+func NewGRPCServer(storage storage.Storage, cfg *config.ServerConfig, trusted *net.IPNet, logger *zap.Logger, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(
+			interceptor.LoggingUnary(logger),
+			interceptor.TrustedSubnetUnary(trusted),
+			interceptor.HashUnary(cfg.Key),
+			interceptor.IdentityUnary(),
+		),
+		grpc.ChainStreamInterceptor(
+			interceptor.LoggingStream(logger),
+			interceptor.TrustedSubnetStream(trusted),
+			interceptor.IdentityStream(),
+		),
+	)
+	srv := grpc.NewServer(opts...)
+	pb.RegisterMetricsServiceServer(srv, &metricsService{storage: storage, key: cfg.Key})
+	return srv
+}
+
+func (s *metricsService) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	n, err := s.update(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.UpdateMetricsResponse{Accepted: uint64(n)}, nil
+}
+
+func (s *metricsService) StreamMetrics(stream grpc.ClientStreamingServer[pb.UpdateMetricsRequest, pb.UpdateMetricsResponse]) error {
+	var accepted uint64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&pb.UpdateMetricsResponse{Accepted: accepted})
+		}
+		if err != nil {
+			return err
+		}
+		if s.key != "" && !pb.VerifyRequest(req, s.key) {
+			return status.Error(codes.InvalidArgument, "invalid signature")
+		}
+		n, err := s.update(stream.Context(), req)
+		if err != nil {
+			return err
+		}
+		accepted += uint64(n)
+	}
+}
+
+// update validates a batch, attributes it to the caller and writes it as a
+// whole, like the /updates/ handler.
+func (s *metricsService) update(ctx context.Context, req *pb.UpdateMetricsRequest) (int, error) {
+	id := identity.FromContext(ctx)
+	metrics := make([]storage.Metric, len(req.GetMetrics()))
+	for i, pm := range req.GetMetrics() {
+		m := pm.ToMetric()
+		if err := m.Validate(); err != nil {
+			return 0, status.Error(codes.InvalidArgument, fmt.Sprintf("metric %q: %v", m.ID, err))
+		}
+		if id.Tenant != "" {
+			m.Tenant = id.Tenant
+		}
+		if m.Source == "" || id.Verified {
+			m.Source = id.Source
+		}
+		metrics[i] = m
+	}
+
+	if err := s.storage.UpdateMetrics(metrics); err != nil {
+		return 0, status.Error(codes.Internal, "failed to update")
+	}
+	return len(metrics), nil
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/agent/sender"
+	"github.com/yokitheyo/guardian-metrics/internal/certs"
+	"github.com/yokitheyo/guardian-metrics/internal/certs/certstest"
+	"github.com/yokitheyo/guardian-metrics/internal/config"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"github.com/yokitheyo/guardian-metrics/internal/pb"
+	"github.com/yokitheyo/guardian-metrics/internal/sign"
+	storagepkg "github.com/yokitheyo/guardian-metrics/internal/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGRPC serves the gRPC service in memory and returns a client for it.
+func startGRPC(t *testing.T, storage storagepkg.Storage, cfg *config.ServerConfig) pb.MetricsServiceClient {
+	t.Helper()
+	trusted, err := parseTrustedSubnet(cfg.TrustedSubnet)
+	require.NoError(t, err)
+
+	lis := bufconn.Listen(1 << 20)
+	srv := NewGRPCServer(storage, cfg, trusted, zap.NewNop())
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return pb.NewMetricsServiceClient(conn)
+}
+
+func TestGRPC_UpdateMetrics(t *testing.T) {
+	storage := storagepkg.NewMemStorage()
+	client := startGRPC(t, storage, &config.ServerConfig{})
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(),
+		identity.AgentHeader, "host-1", identity.TenantHeader, "team-a")
+	resp, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: pb.FromMetrics([]storagepkg.Metric{
+		storagepkg.NewGauge("Alloc", 1.5),
+		storagepkg.NewCounter("PollCount", 3),
+	})})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), resp.GetAccepted())
+
+	v, err := storagepkg.FindOne(storage, storagepkg.Selector{MType: storagepkg.Gauge, ID: "Alloc", Tenant: "team-a"})
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, *v.Value)
+	assert.Equal(t, "host-1", v.Source)
+}
+
+func TestGRPC_UpdateMetricsInvalid(t *testing.T) {
+	storage := storagepkg.NewMemStorage()
+	client := startGRPC(t, storage, &config.ServerConfig{})
+
+	_, err := client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		pb.FromMetric(storagepkg.NewGauge("Alloc", 1)),
+		{Id: "Broken", Type: pb.MetricType_METRIC_TYPE_COUNTER},
+	}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Empty(t, storage.GetAll(), "an invalid batch should not be written at all")
+}
+
+func TestGRPC_StreamMetrics(t *testing.T) {
+	storage := storagepkg.NewMemStorage()
+	client := startGRPC(t, storage, &config.ServerConfig{})
+
+	stream, err := client.StreamMetrics(context.Background())
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, stream.Send(&pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+			pb.FromMetric(storagepkg.NewCounter("Requests", 2)),
+		}}))
+	}
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), resp.GetAccepted())
+
+	v, ok := storage.GetCounter("Requests")
+	require.True(t, ok)
+	assert.Equal(t, int64(6), v)
+}
+
+func TestGRPC_Signature(t *testing.T) {
+	storage := storagepkg.NewMemStorage()
+	client := startGRPC(t, storage, &config.ServerConfig{Key: "secret"})
+
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{pb.FromMetric(storagepkg.NewGauge("Alloc", 1))}}
+	data, err := pb.MarshalSigned(req)
+	require.NoError(t, err)
+
+	_, err = client.UpdateMetrics(context.Background(), req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "unsigned call")
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), sign.Header, sign.Sum(data, "other"))
+	_, err = client.UpdateMetrics(ctx, req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "wrong key")
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), sign.Header, sign.Sum(data, "secret"))
+	_, err = client.UpdateMetrics(ctx, req)
+	assert.NoError(t, err)
+
+	stream, err := client.StreamMetrics(context.Background())
+	require.NoError(t, err)
+	signed := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{pb.FromMetric(storagepkg.NewCounter("PollCount", 2))}}
+	require.NoError(t, pb.SignRequest(signed, "secret"))
+	require.NoError(t, stream.Send(signed))
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), resp.GetAccepted(), "signed stream messages are accepted")
+
+	stream, err = client.StreamMetrics(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(req))
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "unsigned stream message")
+}
+
+func TestGRPC_TrustedSubnet(t *testing.T) {
+	storage := storagepkg.NewMemStorage()
+	client := startGRPC(t, storage, &config.ServerConfig{TrustedSubnet: "10.0.0.0/8"})
+	req := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{pb.FromMetric(storagepkg.NewGauge("Alloc", 1))}}
+
+	_, err := client.UpdateMetrics(context.Background(), req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "missing address")
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), identity.RealIPHeader, "192.168.0.1")
+	_, err = client.UpdateMetrics(ctx, req)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "outside the subnet")
+
+	stream, err := client.StreamMetrics(ctx)
+	require.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "streams are checked too")
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), identity.RealIPHeader, "10.1.2.3")
+	_, err = client.UpdateMetrics(ctx, req)
+	assert.NoError(t, err)
+}
+
+func TestGRPC_ClientCertificate(t *testing.T) {
+	ca := certstest.NewCA(t, "test")
+	serverCert, serverKey := ca.Server("server")
+	clientCert, clientKey := ca.Client("agent-1")
+
+	reloader, err := certs.NewReloader(serverCert, serverKey, ca.CertPath)
+	require.NoError(t, err)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	storage := storagepkg.NewMemStorage()
+	srv := NewGRPCServer(storage, &config.ServerConfig{}, nil, zap.NewNop(), grpc.Creds(credentials.NewTLS(reloader.TLSConfig())))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	tlsConfig, err := certs.ClientConfig(ca.CertPath, clientCert, clientKey)
+	require.NoError(t, err)
+	s, err := sender.NewGRPCSender(lis.Addr().String(), "", nil, identity.Identity{Source: "spoofed"}, tlsConfig)
+	require.NoError(t, err)
+	defer s.Close()
+	claimed := storagepkg.NewGauge("HeapAlloc", 2)
+	claimed.Source = "other-agent"
+	require.NoError(t, s.SendBatch(context.Background(), []storagepkg.Metric{storagepkg.NewGauge("Alloc", 1), claimed}))
+
+	v, err := storagepkg.FindOne(storage, storagepkg.Selector{MType: storagepkg.Gauge, ID: "Alloc"})
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", v.Source, "the certificate name should override the agent header")
+
+	v, err = storagepkg.FindOne(storage, storagepkg.Selector{MType: storagepkg.Gauge, ID: "HeapAlloc"})
+	require.NoError(t, err)
+	assert.Equal(t, "agent-1", v.Source, "the certificate name should override the source in the body")
+}
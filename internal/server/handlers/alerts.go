@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yokitheyo/guardian-metrics/internal/alerting"
+)
+
+// AlertLister is implemented by alerting.Evaluator.
+type AlertLister interface {
+	Alerts() []alerting.Alert
+}
+
+// AlertsHandler lists the pending, firing and recently resolved alerts of
+// the requesting tenant.
+func AlertsHandler(alerts AlertLister) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tenant := requestTenant(c, c.Query(tenantParam))
+
+		result := []alerting.Alert{}
+		for _, a := range alerts.Alerts() {
+			if a.Tenant == tenant {
+				result = append(result, a)
+			}
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
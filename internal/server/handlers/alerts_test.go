@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/alerting"
+)
+
+type staticAlerts []alerting.Alert
+
+func (a staticAlerts) Alerts() []alerting.Alert {
+	return a
+}
+
+func TestAlertsHandler_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	alerts := staticAlerts{
+		{Rule: "HighHeap", State: alerting.StateFiring, ID: "HeapInuse"},
+		{Rule: "AgentDown", State: alerting.StatePending, ID: "PollCount", Tenant: "team-a"},
+	}
+
+	r := gin.New()
+	r.GET("/alerts", AlertsHandler(alerts))
+
+	get := func(path string) []alerting.Alert {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var result []alerting.Alert
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &result))
+		return result
+	}
+
+	result := get("/alerts")
+	require.Len(t, result, 1)
+	assert.Equal(t, "HighHeap", result[0].Rule)
+
+	result = get("/alerts?tenant=team-a")
+	require.Len(t, result, 1)
+	assert.Equal(t, "AgentDown", result[0].Rule)
+
+	assert.Empty(t, get("/alerts?tenant=team-b"))
+}
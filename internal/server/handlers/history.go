@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	storagepkg "github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+// Query parameters of the history endpoint that are not label matchers.
+const (
+	fromParam = "from"
+	toParam   = "to"
+	stepParam = "step"
+)
+
+type historyResponse struct {
+	ID     string                `json:"id"`
+	MType  storagepkg.MetricType `json:"type"`
+	Labels storagepkg.Labels     `json:"labels,omitempty"`
+	Source string                `json:"source,omitempty"`
+	Tenant string                `json:"tenant,omitempty"`
+	Points []storagepkg.Point    `json:"points"`
+}
+
+// HistoryHandler returns the recorded points of one series between from and
+// to, given as RFC 3339 or unix seconds and defaulting to all history up to
+// now. A step such as 30s keeps only the last point of every window. Other
+// query parameters select the series as in GetMetricValueHandler.
+func HistoryHandler(storage storagepkg.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mType := storagepkg.MetricType(c.Param("type"))
+		if mType != storagepkg.Gauge && mType != storagepkg.Counter {
+			c.JSON(http.StatusNotFound, gin.H{"error": "metric not found"})
+			return
+		}
+
+		to, err := parseTimeParam(c.Query(toParam), time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: %v", toParam, err)})
+			return
+		}
+		from, err := parseTimeParam(c.Query(fromParam), time.Time{})
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: %v", fromParam, err)})
+			return
+		}
+		step, err := parseDurationParam(c.Query(stepParam), 0)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: %v", stepParam, err)})
+			return
+		}
+
+		m, err := findSeries(c, storage, mType, fromParam, toParam, stepParam)
+		if err != nil {
+			c.JSON(findErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		points, err := storage.Range(m, from, to)
+		if err != nil {
+			rangeError(c, err)
+			return
+		}
+		points = storagepkg.Downsample(points, step)
+
+		c.JSON(http.StatusOK, newHistoryResponse(m, points))
+	}
+}
+
+func newHistoryResponse(m storagepkg.Metric, points []storagepkg.Point) historyResponse {
+	if points == nil {
+		points = []storagepkg.Point{}
+	}
+	return historyResponse{
+		ID:     m.ID,
+		MType:  m.MType,
+		Labels: m.Labels,
+		Source: m.Source,
+		Tenant: m.Tenant,
+		Points: points,
+	}
+}
+
+// findSeries resolves the series named in the path, treating query
+// parameters other than source, tenant and reserved as label matchers.
+func findSeries(c *gin.Context, storage storagepkg.Storage, mType storagepkg.MetricType, reserved ...string) (storagepkg.Metric, error) {
+	return storagepkg.FindOne(storage, storagepkg.Selector{
+		MType:    mType,
+		ID:       c.Param("name"),
+		Matchers: labelsFromQuery(c, reserved...),
+		Source:   c.Query(sourceParam),
+		Tenant:   requestTenant(c, c.Query(tenantParam)),
+	})
+}
+
+func rangeError(c *gin.Context, err error) {
+	if errors.Is(err, storagepkg.ErrHistoryUnsupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read history"})
+}
+
+// parseTimeParam accepts RFC 3339 or unix seconds, returning def for an
+// empty value.
+func parseTimeParam(v string, def time.Time) (time.Time, error) {
+	if v == "" {
+		return def, nil
+	}
+	if sec, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// parseDurationParam accepts a duration such as 30s or a number of seconds,
+// returning def for an empty value.
+func parseDurationParam(v string, def time.Duration) (time.Duration, error) {
+	if v == "" {
+		return def, nil
+	}
+	step, err := time.ParseDuration(v)
+	if sec, atoiErr := strconv.Atoi(v); atoiErr == nil {
+		step, err = time.Duration(sec)*time.Second, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if step < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return step, nil
+}
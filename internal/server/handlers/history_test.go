@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	storagepkg "github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+func TestHistoryHandler_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage := storagepkg.NewMemStorage()
+	for _, v := range []float64{1, 2, 3} {
+		val := v
+		require.NoError(t, storage.UpdateMetric(storagepkg.Metric{
+			ID: "HeapAlloc", MType: storagepkg.Gauge, Value: &val, Labels: storagepkg.Labels{"host": "a"},
+		}))
+	}
+
+	r := gin.New()
+	r.GET("/history/:type/:name", HistoryHandler(storage))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	rr := get("/history/gauge/HeapAlloc?host=a")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp historyResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "HeapAlloc", resp.ID)
+	assert.Equal(t, storagepkg.Labels{"host": "a"}, resp.Labels)
+	require.Len(t, resp.Points, 3)
+	assert.Equal(t, 3.0, resp.Points[2].Value)
+
+	rr = get("/history/gauge/HeapAlloc?step=1h")
+	require.Equal(t, http.StatusOK, rr.Code)
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	require.Len(t, resp.Points, 1, "all points fall into one window")
+	assert.Equal(t, 3.0, resp.Points[0].Value)
+
+	rr = get("/history/gauge/HeapAlloc?to=0")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"id":"HeapAlloc","type":"gauge","labels":{"host":"a"},"points":[]}`, rr.Body.String())
+
+	assert.Equal(t, http.StatusBadRequest, get("/history/gauge/HeapAlloc?from=yesterday").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/history/gauge/HeapAlloc?step=-1s").Code)
+	assert.Equal(t, http.StatusNotFound, get("/history/gauge/Missing").Code)
+	assert.Equal(t, http.StatusNotFound, get("/history/unknown/HeapAlloc").Code)
+}
+
+func TestHistoryHandler_Unsupported(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	val := 1.0
+	storage := &MockStorage{metrics: []storagepkg.Metric{{ID: "HeapAlloc", MType: storagepkg.Gauge, Value: &val}}}
+
+	r := gin.New()
+	r.GET("/history/:type/:name", HistoryHandler(storage))
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/history/gauge/HeapAlloc", nil))
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
@@ -1,12 +1,17 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"html/template"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
 	storagepkg "github.com/yokitheyo/guardian-metrics/internal/storage"
 )
 
@@ -19,6 +24,10 @@ func UpdateMetricHandler(storage storagepkg.Storage) gin.HandlerFunc {
 		var m storagepkg.Metric
 		m.ID = name
 		m.MType = storagepkg.MetricType(mType)
+		m.Labels = labelsFromQuery(c)
+		m.Source = c.Query(sourceParam)
+		m.Tenant = c.Query(tenantParam)
+		stampIdentity(c, &m)
 
 		switch m.MType {
 		case storagepkg.Gauge:
@@ -35,10 +44,22 @@ func UpdateMetricHandler(storage storagepkg.Storage) gin.HandlerFunc {
 				return
 			}
 			m.Delta = &delta
+		case storagepkg.Histogram:
+			observed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				c.String(http.StatusBadRequest, "invalid histogram observation")
+				return
+			}
+			m.Histogram = storagepkg.NewHistogram(storedBuckets(storage, m))
+			m.Histogram.Observe(observed)
 		default:
 			c.String(http.StatusBadRequest, "invalid metric type")
 			return
 		}
+		if err := m.Labels.Validate(); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
 
 		if err := storage.UpdateMetric(m); err != nil {
 			c.String(http.StatusInternalServerError, "failed to update")
@@ -48,41 +69,108 @@ func UpdateMetricHandler(storage storagepkg.Storage) gin.HandlerFunc {
 	}
 }
 
-func GetMetricValueHandler(storage storagepkg.Storage) gin.HandlerFunc {
+func UpdateMetricJSONHandler(storage storagepkg.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		mType := c.Param("type")
-		name := c.Param("name")
-		var (
-			found  bool
-			result string
-		)
-		switch storagepkg.MetricType(mType) {
-		case storagepkg.Gauge:
-			var val float64
-			val, found = storage.GetGauge(name)
-			if found {
-				result = strconv.FormatFloat(val, 'f', -1, 64)
-			}
-		case storagepkg.Counter:
-			var val int64
-			val, found = storage.GetCounter(name)
-			if found {
-				result = fmt.Sprintf("%d", val)
+		var m storagepkg.Metric
+		if err := c.ShouldBindJSON(&m); err != nil {
+			invalidBody(c, err)
+			return
+		}
+		if err := m.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		stampIdentity(c, &m)
+
+		if err := storage.UpdateMetric(m); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update"})
+			return
+		}
+
+		result, err := storagepkg.FindOne(storage, storagepkg.Selector{
+			MType:    m.MType,
+			ID:       m.ID,
+			Matchers: m.Labels,
+			Source:   m.Source,
+			Tenant:   m.Tenant,
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "metric not stored"})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+func UpdateMetricsBatchHandler(storage storagepkg.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var metrics []storagepkg.Metric
+		if err := c.ShouldBindJSON(&metrics); err != nil {
+			invalidBody(c, err)
+			return
+		}
+		for i := range metrics {
+			if err := metrics[i].Validate(); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("metric %q: %v", metrics[i].ID, err)})
+				return
 			}
+			stampIdentity(c, &metrics[i])
 		}
-		if !found {
+
+		if err := storage.UpdateMetrics(metrics); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"updated": len(metrics)})
+	}
+}
+
+// GetMetricValueHandler returns the plain text value of one series. Query
+// parameters act as label matchers, e.g. /value/gauge/Alloc?host=a, except
+// for source and tenant which select the agent and namespace.
+func GetMetricValueHandler(storage storagepkg.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mType := storagepkg.MetricType(c.Param("type"))
+		if mType != storagepkg.Gauge && mType != storagepkg.Counter && mType != storagepkg.Histogram {
 			c.String(http.StatusNotFound, "metric not found")
 			return
 		}
+
+		m, err := storagepkg.FindOne(storage, storagepkg.Selector{
+			MType:    mType,
+			ID:       c.Param("name"),
+			Matchers: labelsFromQuery(c),
+			Source:   c.Query(sourceParam),
+			Tenant:   requestTenant(c, c.Query(tenantParam)),
+		})
+		if err != nil {
+			c.String(findErrorStatus(err), err.Error())
+			return
+		}
+
+		var result string
+		switch {
+		case m.Value != nil:
+			result = strconv.FormatFloat(*m.Value, 'f', -1, 64)
+		case m.Delta != nil:
+			result = fmt.Sprintf("%d", *m.Delta)
+		case m.Histogram != nil:
+			result = formatHistogram(m.Histogram)
+		}
 		c.String(http.StatusOK, result)
 	}
 }
 
+// ListMetricsHandler renders the series of one tenant, optionally narrowed
+// to a single agent with ?source=.
 func ListMetricsHandler(storage storagepkg.Storage) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		metrics := storage.GetAll()
-		tmpl := `<html><body><h1>Metrics</h1><table border="1"><tr><th>Name</th><th>Type</th><th>Value</th></tr>{{range .}}<tr><td>{{.ID}}</td><td>{{.MType}}</td><td>{{if eq .MType "gauge"}}{{with .Value}}{{printf "%f" .}}{{end}}{{else}}{{.Delta}}{{end}}</td></tr>{{end}}</table></body></html>`
-		t, err := template.New("metrics").Parse(tmpl)
+		metrics := storage.Query(storagepkg.Selector{
+			Source: c.Query(sourceParam),
+			Tenant: requestTenant(c, c.Query(tenantParam)),
+		})
+		tmpl := `<html><body><h1>Metrics</h1><table border="1"><tr><th>Name</th><th>Source</th><th>Labels</th><th>Type</th><th>Value</th></tr>{{range .}}<tr><td>{{.ID}}</td><td>{{.Source}}</td><td>{{formatLabels .Labels}}</td><td>{{.MType}}</td><td>{{if eq .MType "gauge"}}{{with .Value}}{{printf "%f" .}}{{end}}{{else if eq .MType "histogram"}}{{formatHistogram .Histogram}}{{else}}{{.Delta}}{{end}}</td></tr>{{end}}</table></body></html>`
+		t, err := template.New("metrics").Funcs(template.FuncMap{"formatLabels": formatLabels, "formatHistogram": formatHistogram}).Parse(tmpl)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "template error")
 			return
@@ -92,3 +180,161 @@ func ListMetricsHandler(storage storagepkg.Storage) gin.HandlerFunc {
 		t.Execute(c.Writer, metrics)
 	}
 }
+
+func GetMetricValueJSONHandler(storage storagepkg.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req storagepkg.Metric
+		if err := c.ShouldBindJSON(&req); err != nil {
+			invalidBody(c, err)
+			return
+		}
+		if req.ID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": storagepkg.ErrEmptyID.Error()})
+			return
+		}
+		if req.MType != storagepkg.Gauge && req.MType != storagepkg.Counter && req.MType != storagepkg.Histogram {
+			c.JSON(http.StatusBadRequest, gin.H{"error": storagepkg.ErrInvalidType.Error()})
+			return
+		}
+
+		result, err := storagepkg.FindOne(storage, storagepkg.Selector{
+			MType:    req.MType,
+			ID:       req.ID,
+			Matchers: req.Labels,
+			Source:   req.Source,
+			Tenant:   requestTenant(c, req.Tenant),
+		})
+		if err != nil {
+			c.JSON(findErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// Query parameters that pick the source and tenant instead of a label.
+const (
+	sourceParam = "source"
+	tenantParam = "tenant"
+)
+
+// labelsFromQuery turns query parameters into labels, keeping the first value
+// of each parameter. The source, tenant and reserved parameters are skipped.
+func labelsFromQuery(c *gin.Context, reserved ...string) storagepkg.Labels {
+	query := c.Request.URL.Query()
+	delete(query, sourceParam)
+	delete(query, tenantParam)
+	for _, name := range reserved {
+		delete(query, name)
+	}
+	if len(query) == 0 {
+		return nil
+	}
+	labels := make(storagepkg.Labels, len(query))
+	for name, values := range query {
+		labels[name] = values[0]
+	}
+	return labels
+}
+
+// stampIdentity attributes m to the sender of the request: the tenant header
+// overrides the tenant named in the metric and the agent header fills in a
+// missing source. A verified client certificate overrides the source.
+func stampIdentity(c *gin.Context, m *storagepkg.Metric) {
+	id := identity.FromContext(c.Request.Context())
+	if id.Tenant != "" {
+		m.Tenant = id.Tenant
+	}
+	if m.Source == "" || id.Verified {
+		m.Source = id.Source
+	}
+}
+
+// requestTenant returns the tenant from the request header, or fallback when
+// the header is not set.
+func requestTenant(c *gin.Context, fallback string) string {
+	if tenant := identity.FromContext(c.Request.Context()).Tenant; tenant != "" {
+		return tenant
+	}
+	return fallback
+}
+
+// invalidBody answers a request whose JSON body could not be read, with 413
+// when it was cut off for being too large.
+func invalidBody(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "body is too large"})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json body"})
+}
+
+func findErrorStatus(err error) int {
+	if errors.Is(err, storagepkg.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// storedBuckets returns the buckets of the histogram series m would update,
+// or the default buckets for a new series.
+func storedBuckets(storage storagepkg.Storage, m storagepkg.Metric) []float64 {
+	stored, err := storagepkg.FindOne(storage, storagepkg.Selector{
+		MType:    m.MType,
+		ID:       m.ID,
+		Matchers: m.Labels,
+		Source:   m.Source,
+		Tenant:   m.Tenant,
+	})
+	if err != nil || stored.Histogram == nil || stored.Labels.Key() != m.Labels.Key() || stored.Source != m.Source {
+		return storagepkg.DefaultBuckets
+	}
+	return stored.Histogram.Buckets
+}
+
+// formatHistogram renders the count, sum and cumulative bucket counts, e.g.
+// "count=3 sum=1.5 le_0.1=1 le_1=2 le_+Inf=3".
+func formatHistogram(h *storagepkg.HistogramValue) string {
+	if h == nil {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "count=%d sum=%s", h.Count, strconv.FormatFloat(h.Sum, 'f', -1, 64))
+	for i, n := range h.Cumulative() {
+		bound := "+Inf"
+		if i < len(h.Buckets) {
+			bound = strconv.FormatFloat(h.Buckets[i], 'f', -1, 64)
+		}
+		fmt.Fprintf(&b, " le_%s=%d", bound, n)
+	}
+	return b.String()
+}
+
+// formatLabels renders labels as "name=value" pairs sorted by name.
+func formatLabels(labels storagepkg.Labels) string {
+	pairs := make([]string, 0, len(labels))
+	for _, name := range labels.Names() {
+		pairs = append(pairs, name+"="+labels[name])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func PingHandler(storage storagepkg.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		pinger, ok := storage.(storagepkg.Pinger)
+		if !ok {
+			c.String(http.StatusInternalServerError, "database is not configured")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), time.Second)
+		defer cancel()
+
+		if err := pinger.Ping(ctx); err != nil {
+			c.String(http.StatusInternalServerError, "database is unavailable")
+			return
+		}
+		c.String(http.StatusOK, "OK")
+	}
+}
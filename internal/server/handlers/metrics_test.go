@@ -1,14 +1,24 @@
 package handler
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"github.com/yokitheyo/guardian-metrics/internal/server/middleware"
 	storagepkg "github.com/yokitheyo/guardian-metrics/internal/storage"
 )
 
@@ -21,10 +31,29 @@ func (m *MockStorage) UpdateMetric(metric storagepkg.Metric) error {
 	return nil
 }
 
+func (m *MockStorage) UpdateMetrics(metrics []storagepkg.Metric) error {
+	m.metrics = append(m.metrics, metrics...)
+	return nil
+}
+
 func (m *MockStorage) GetAll() []storagepkg.Metric {
 	return m.metrics
 }
 
+func (m *MockStorage) Query(sel storagepkg.Selector) []storagepkg.Metric {
+	var result []storagepkg.Metric
+	for _, metric := range m.metrics {
+		if sel.Matches(metric) {
+			result = append(result, metric)
+		}
+	}
+	return result
+}
+
+func (m *MockStorage) Range(storagepkg.Metric, time.Time, time.Time) ([]storagepkg.Point, error) {
+	return nil, storagepkg.ErrHistoryUnsupported
+}
+
 func (m *MockStorage) GetGauge(name string) (float64, bool) {
 	for _, metric := range m.metrics {
 		if metric.ID == name && metric.MType == storagepkg.Gauge && metric.Value != nil {
@@ -248,6 +277,12 @@ func TestGetMetricValueHandler_Gin(t *testing.T) {
 		assert.Equal(t, http.StatusOK, rr.Code)
 		assert.Contains(t, rr.Body.String(), "10")
 	})
+	t.Run("invalid type", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/value/invalid/gaugeMetric", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
 	t.Run("not found", func(t *testing.T) {
 		req := httptest.NewRequest(http.MethodGet, "/value/gauge/unknown", nil)
 		rr := httptest.NewRecorder()
@@ -257,6 +292,407 @@ func TestGetMetricValueHandler_Gin(t *testing.T) {
 	})
 }
 
+func TestUpdateMetricJSONHandler_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "valid gauge metric",
+			body:           `{"id":"someMetric","type":"gauge","value":42.5}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":"someMetric","type":"gauge","value":42.5}`,
+		},
+		{
+			name:           "valid counter metric",
+			body:           `{"id":"someMetric","type":"counter","delta":527}`,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"id":"someMetric","type":"counter","delta":527}`,
+		},
+		{
+			name:           "malformed json",
+			body:           `{"id":`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid json body"}`,
+		},
+		{
+			name:           "empty id",
+			body:           `{"type":"gauge","value":1}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"metric id is empty"}`,
+		},
+		{
+			name:           "invalid metric type",
+			body:           `{"id":"someMetric","type":"invalid","value":1}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"invalid metric type"}`,
+		},
+		{
+			name:           "gauge without value",
+			body:           `{"id":"someMetric","type":"gauge","delta":1}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"gauge value is missing"}`,
+		},
+		{
+			name:           "counter without delta",
+			body:           `{"id":"someMetric","type":"counter","value":1}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"error":"counter delta is missing"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &MockStorage{}
+			r := gin.New()
+			r.POST("/update/", UpdateMetricJSONHandler(storage))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.JSONEq(t, tt.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestUpdateMetricsBatchHandler_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedStored int
+	}{
+		{
+			name:           "valid batch",
+			body:           `[{"id":"g","type":"gauge","value":1.5},{"id":"c","type":"counter","delta":3}]`,
+			expectedStatus: http.StatusOK,
+			expectedStored: 2,
+		},
+		{
+			name:           "empty batch",
+			body:           `[]`,
+			expectedStatus: http.StatusOK,
+			expectedStored: 0,
+		},
+		{
+			name:           "one invalid metric rejects the batch",
+			body:           `[{"id":"g","type":"gauge","value":1.5},{"id":"c","type":"counter"}]`,
+			expectedStatus: http.StatusBadRequest,
+			expectedStored: 0,
+		},
+		{
+			name:           "not an array",
+			body:           `{"id":"g","type":"gauge","value":1.5}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedStored: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &MockStorage{}
+			r := gin.New()
+			r.POST("/updates/", UpdateMetricsBatchHandler(storage))
+
+			req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+			assert.Len(t, storage.metrics, tt.expectedStored)
+		})
+	}
+}
+
+func TestUpdateMetricsBatchHandler_BodyTooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage := &MockStorage{}
+	r := gin.New()
+	r.POST("/updates/", UpdateMetricsBatchHandler(storage))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(`[{"id":"g","type":"gauge","value":1.5}]`))
+	req.Body = http.MaxBytesReader(rr, req.Body, 10)
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	assert.Empty(t, storage.metrics)
+}
+
+func TestGetMetricValueJSONHandler_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage := &MockStorage{}
+	val := 42.5
+	storage.metrics = append(storage.metrics, storagepkg.Metric{ID: "gaugeMetric", MType: storagepkg.Gauge, Value: &val})
+	cnt := int64(10)
+	storage.metrics = append(storage.metrics, storagepkg.Metric{ID: "counterMetric", MType: storagepkg.Counter, Delta: &cnt})
+
+	r := gin.New()
+	r.POST("/value/", GetMetricValueJSONHandler(storage))
+
+	doRequest := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/value/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("existing gauge", func(t *testing.T) {
+		rr := doRequest(`{"id":"gaugeMetric","type":"gauge"}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var m storagepkg.Metric
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &m))
+		require.NotNil(t, m.Value)
+		assert.Equal(t, 42.5, *m.Value)
+		assert.Nil(t, m.Delta)
+	})
+	t.Run("existing counter", func(t *testing.T) {
+		rr := doRequest(`{"id":"counterMetric","type":"counter"}`)
+		require.Equal(t, http.StatusOK, rr.Code)
+
+		var m storagepkg.Metric
+		require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &m))
+		require.NotNil(t, m.Delta)
+		assert.Equal(t, int64(10), *m.Delta)
+	})
+	t.Run("not found", func(t *testing.T) {
+		rr := doRequest(`{"id":"unknown","type":"gauge"}`)
+		assert.Equal(t, http.StatusNotFound, rr.Code)
+	})
+	t.Run("invalid type", func(t *testing.T) {
+		rr := doRequest(`{"id":"gaugeMetric","type":"invalid"}`)
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+type pingStorage struct {
+	MockStorage
+	err error
+}
+
+func (p *pingStorage) Ping(ctx context.Context) error {
+	return p.err
+}
+
+func TestPingHandler_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name           string
+		storage        storagepkg.Storage
+		expectedStatus int
+	}{
+		{
+			name:           "database is healthy",
+			storage:        &pingStorage{},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "database is down",
+			storage:        &pingStorage{err: errors.New("connection refused")},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:           "no database configured",
+			storage:        &MockStorage{},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/ping", PingHandler(tt.storage))
+
+			req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			assert.Equal(t, tt.expectedStatus, rr.Code)
+		})
+	}
+}
+
+func TestMetricLabels_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage := storagepkg.NewMemStorage()
+
+	r := gin.New()
+	r.POST("/update/:type/:name/:value", UpdateMetricHandler(storage))
+	r.POST("/update/", UpdateMetricJSONHandler(storage))
+	r.GET("/value/:type/:name", GetMetricValueHandler(storage))
+	r.POST("/value/", GetMetricValueJSONHandler(storage))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/update/gauge/Alloc/1?host=a&service=api", "").Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/update/", `{"id":"Alloc","type":"gauge","value":2,"labels":{"host":"b","service":"api"}}`).Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/update/gauge/Alloc/1?bad-label=x", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/update/", `{"id":"Alloc","type":"gauge","value":2,"labels":{"1x":"y"}}`).Code)
+
+	rr := do(http.MethodGet, "/value/gauge/Alloc?host=a", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1", rr.Body.String())
+
+	rr = do(http.MethodGet, "/value/gauge/Alloc?service=api", "")
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "two series match")
+
+	rr = do(http.MethodGet, "/value/gauge/Alloc?host=c", "")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = do(http.MethodPost, "/value/", `{"id":"Alloc","type":"gauge","labels":{"host":"b"}}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var m storagepkg.Metric
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &m))
+	assert.Equal(t, 2.0, *m.Value)
+	assert.Equal(t, storagepkg.Labels{"host": "b", "service": "api"}, m.Labels)
+}
+
+func TestMetricSourceAndTenant_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage := storagepkg.NewMemStorage()
+
+	r := gin.New()
+	r.Use(middleware.IdentityMiddleware())
+	r.POST("/update/:type/:name/:value", UpdateMetricHandler(storage))
+	r.POST("/updates/", UpdateMetricsBatchHandler(storage))
+	r.GET("/value/:type/:name", GetMetricValueHandler(storage))
+	r.POST("/value/", GetMetricValueJSONHandler(storage))
+	r.GET("/", ListMetricsHandler(storage))
+
+	do := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	teamA := map[string]string{identity.TenantHeader: "team-a"}
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/updates/", `[{"id":"Alloc","type":"gauge","value":1}]`,
+		map[string]string{identity.AgentHeader: "host-1", identity.TenantHeader: "team-a"}).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/updates/", `[{"id":"Alloc","type":"gauge","value":2}]`,
+		map[string]string{identity.AgentHeader: "host-2", identity.TenantHeader: "team-a"}).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/update/gauge/Alloc/3?tenant=team-b", "", nil).Code)
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/update/gauge/Alloc/4", "", nil).Code)
+
+	rr := do(http.MethodGet, "/value/gauge/Alloc", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "4", rr.Body.String(), "the default tenant does not see other tenants")
+
+	rr = do(http.MethodGet, "/value/gauge/Alloc?tenant=team-b", "", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "3", rr.Body.String())
+
+	rr = do(http.MethodGet, "/value/gauge/Alloc", "", teamA)
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "two agents report Alloc")
+
+	rr = do(http.MethodGet, "/value/gauge/Alloc?source=host-2", "", teamA)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "2", rr.Body.String())
+
+	rr = do(http.MethodPost, "/value/", `{"id":"Alloc","type":"gauge","source":"host-1","tenant":"team-a"}`, nil)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var m storagepkg.Metric
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &m))
+	assert.Equal(t, 1.0, *m.Value)
+	assert.Equal(t, "host-1", m.Source)
+
+	rr = do(http.MethodGet, "/?source=host-1", "", teamA)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "host-1")
+	assert.NotContains(t, rr.Body.String(), "host-2")
+}
+
+func TestMetricSourceFromCertificate_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage := storagepkg.NewMemStorage()
+
+	r := gin.New()
+	r.Use(middleware.IdentityMiddleware())
+	r.POST("/update/", UpdateMetricJSONHandler(storage))
+	r.POST("/updates/", UpdateMetricsBatchHandler(storage))
+
+	verified := &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "agent-1"}}}},
+	}
+	for path, body := range map[string]string{
+		"/update/":  `{"id":"Alloc","type":"gauge","value":1,"source":"other-agent"}`,
+		"/updates/": `[{"id":"HeapAlloc","type":"gauge","value":2,"source":"other-agent"}]`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.TLS = verified
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		require.Equal(t, http.StatusOK, rr.Code, path)
+	}
+
+	for _, id := range []string{"Alloc", "HeapAlloc"} {
+		m, err := storagepkg.FindOne(storage, storagepkg.Selector{MType: storagepkg.Gauge, ID: id})
+		require.NoError(t, err)
+		assert.Equal(t, "agent-1", m.Source, "the certificate name should override the source in the body")
+	}
+}
+
+func TestHistogram_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage := storagepkg.NewMemStorage()
+
+	r := gin.New()
+	r.POST("/update/:type/:name/:value", UpdateMetricHandler(storage))
+	r.POST("/update/", UpdateMetricJSONHandler(storage))
+	r.GET("/value/:type/:name", GetMetricValueHandler(storage))
+	r.POST("/value/", GetMetricValueJSONHandler(storage))
+	r.GET("/", ListMetricsHandler(storage))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/update/", `{"id":"Latency","type":"histogram","histogram":{"buckets":[0.1,1],"counts":[1,0,0],"count":1,"sum":0.05}}`)
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/update/histogram/Latency/0.5", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/update/histogram/Latency/slow", "").Code)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/update/", `{"id":"Latency","type":"histogram"}`).Code)
+	assert.Equal(t, http.StatusBadRequest,
+		do(http.MethodPost, "/update/", `{"id":"Latency","type":"histogram","histogram":{"buckets":[1,0.1],"counts":[0,0,0],"count":0}}`).Code)
+
+	rr = do(http.MethodGet, "/value/histogram/Latency", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "count=2 sum=0.55 le_0.1=1 le_1=2 le_+Inf=2", rr.Body.String())
+
+	require.Equal(t, http.StatusOK, do(http.MethodPost, "/update/histogram/Fresh/0.3", "").Code)
+	rr = do(http.MethodPost, "/value/", `{"id":"Fresh","type":"histogram"}`)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var m storagepkg.Metric
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &m))
+	require.NotNil(t, m.Histogram)
+	assert.Equal(t, storagepkg.DefaultBuckets, m.Histogram.Buckets, "a new series gets the default buckets")
+	assert.Equal(t, uint64(1), m.Histogram.Count)
+
+	rr = do(http.MethodGet, "/", "")
+	assert.Contains(t, rr.Body.String(), "count=2 sum=0.55")
+}
+
 // func TestListMetricsHandler_Gin(t *testing.T) {
 // 	gin.SetMode(gin.TestMode)
 // 	storage := &MockStorage{}
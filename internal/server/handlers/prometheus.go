@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	storagepkg "github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// sanitizeMetricName maps an arbitrary metric id onto the Prometheus name
+// alphabet [a-zA-Z_:][a-zA-Z0-9_:]*, replacing everything else with '_'.
+func sanitizeMetricName(name string) string {
+	if name == "" {
+		return "_"
+	}
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || c == ':' ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	if b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// escapeLabelValue escapes backslashes, double quotes and newlines as the
+// exposition format requires.
+func escapeLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// escapeHelp escapes backslashes and newlines in HELP text as the
+// exposition format requires.
+func escapeHelp(v string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(v)
+}
+
+// formatPrometheusLabels renders labels as {name="value",...} sorted by name,
+// or nothing for an empty set.
+func formatPrometheusLabels(labels storagepkg.Labels) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for _, name := range labels.Names() {
+		pairs = append(pairs, name+`="`+escapeLabelValue(labels[name])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// exposedLabels returns the labels of m with its source and tenant added as
+// "source" and "tenant" labels, which take precedence over user labels of
+// the same name.
+func exposedLabels(m storagepkg.Metric) storagepkg.Labels {
+	if m.Source == "" && m.Tenant == "" {
+		return m.Labels
+	}
+	labels := m.Labels.Clone()
+	if labels == nil {
+		labels = make(storagepkg.Labels, 2)
+	}
+	if m.Source != "" {
+		labels["source"] = m.Source
+	}
+	if m.Tenant != "" {
+		labels["tenant"] = m.Tenant
+	}
+	return labels
+}
+
+// writeHistogram renders the cumulative _bucket samples, one per bound
+// including +Inf, followed by _sum and _count.
+func writeHistogram(buf *bytes.Buffer, family string, labels storagepkg.Labels, h *storagepkg.HistogramValue) {
+	bucketLabels := labels.Clone()
+	if bucketLabels == nil {
+		bucketLabels = make(storagepkg.Labels, 1)
+	}
+	for i, n := range h.Cumulative() {
+		bucketLabels["le"] = "+Inf"
+		if i < len(h.Buckets) {
+			bucketLabels["le"] = strconv.FormatFloat(h.Buckets[i], 'g', -1, 64)
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", family, formatPrometheusLabels(bucketLabels), n)
+	}
+	fmt.Fprintf(buf, "%s_sum%s %s\n", family, formatPrometheusLabels(labels), strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	fmt.Fprintf(buf, "%s_count%s %d\n", family, formatPrometheusLabels(labels), h.Count)
+}
+
+// PrometheusHandler renders every stored metric of every tenant in the
+// Prometheus text exposition format, one family per metric id and type with
+// a sample per tenant, source and label set.
+func PrometheusHandler(storage storagepkg.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// GetAll is sorted by id and type first, so every family is a
+		// contiguous run.
+		metrics := storage.GetAll()
+
+		var (
+			buf      bytes.Buffer
+			family   string
+			lastID   string
+			lastType storagepkg.MetricType
+		)
+		used := make(map[string]bool, len(metrics))
+		for _, m := range metrics {
+			var value string
+			switch m.MType {
+			case storagepkg.Gauge:
+				if m.Value == nil {
+					continue
+				}
+				value = strconv.FormatFloat(*m.Value, 'g', -1, 64)
+			case storagepkg.Counter:
+				if m.Delta == nil {
+					continue
+				}
+				value = strconv.FormatInt(*m.Delta, 10)
+			case storagepkg.Histogram:
+				if m.Histogram == nil {
+					continue
+				}
+			default:
+				continue
+			}
+
+			if family == "" || m.ID != lastID || m.MType != lastType {
+				lastID, lastType = m.ID, m.MType
+
+				// Series of different types may share an id but not a family
+				// name.
+				family = sanitizeMetricName(m.ID)
+				if used[family] {
+					family += "_" + string(m.MType)
+				}
+				used[family] = true
+
+				fmt.Fprintf(&buf, "# HELP %s %s %s.\n", family, m.MType, escapeHelp(m.ID))
+				fmt.Fprintf(&buf, "# TYPE %s %s\n", family, m.MType)
+			}
+
+			labels := exposedLabels(m)
+			if m.MType == storagepkg.Histogram {
+				writeHistogram(&buf, family, labels, m.Histogram)
+				continue
+			}
+			fmt.Fprintf(&buf, "%s%s %s\n", family, formatPrometheusLabels(labels), value)
+		}
+
+		c.Data(http.StatusOK, prometheusContentType, buf.Bytes())
+	}
+}
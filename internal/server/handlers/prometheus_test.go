@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	storagepkg "github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+func TestSanitizeMetricName(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "HeapAlloc", want: "HeapAlloc"},
+		{in: "http.requests-total", want: "http_requests_total"},
+		{in: "cpu:util_1", want: "cpu:util_1"},
+		{in: "1stMetric", want: "_1stMetric"},
+		{in: "темп", want: "________"},
+		{in: "", want: "_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeMetricName(tt.in))
+		})
+	}
+}
+
+func TestPrometheusHandler_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage := &MockStorage{}
+	val := 1.5
+	cnt := int64(7)
+	dup := 2.0
+	hostA := 3.0
+	hostB := 4.0
+	storage.metrics = append(storage.metrics,
+		storagepkg.Metric{ID: "Alloc", MType: storagepkg.Gauge, Value: &hostA, Labels: storagepkg.Labels{"host": "a", "note": `say "hi"`}},
+		storagepkg.Metric{ID: "Alloc", MType: storagepkg.Gauge, Value: &hostB, Labels: storagepkg.Labels{"host": "b"}},
+		storagepkg.Metric{ID: "Alloc", MType: storagepkg.Gauge, Value: &val, Source: "host-1", Tenant: "team-a"},
+		storagepkg.Metric{ID: "Heap.Alloc", MType: storagepkg.Gauge, Value: &val},
+		storagepkg.Metric{ID: "PollCount", MType: storagepkg.Counter, Delta: &cnt},
+		storagepkg.Metric{ID: "PollCount", MType: storagepkg.Gauge, Value: &dup},
+	)
+
+	r := gin.New()
+	r.GET("/metrics", PrometheusHandler(storage))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, prometheusContentType, rr.Header().Get("Content-Type"))
+
+	expected := `# HELP Alloc gauge Alloc.
+# TYPE Alloc gauge
+Alloc{host="a",note="say \"hi\""} 3
+Alloc{host="b"} 4
+Alloc{source="host-1",tenant="team-a"} 1.5
+# HELP Heap_Alloc gauge Heap.Alloc.
+# TYPE Heap_Alloc gauge
+Heap_Alloc 1.5
+# HELP PollCount counter PollCount.
+# TYPE PollCount counter
+PollCount 7
+# HELP PollCount_gauge gauge PollCount.
+# TYPE PollCount_gauge gauge
+PollCount_gauge 2
+`
+	assert.Equal(t, expected, rr.Body.String())
+}
+
+func TestPrometheusHandler_EscapesHelp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	val := 1.0
+	storage := &MockStorage{metrics: []storagepkg.Metric{
+		{ID: "Bad\nName\\", MType: storagepkg.Gauge, Value: &val},
+	}}
+
+	r := gin.New()
+	r.GET("/metrics", PrometheusHandler(storage))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	expected := `# HELP Bad_Name_ gauge Bad\nName\\.
+# TYPE Bad_Name_ gauge
+Bad_Name_ 1
+`
+	assert.Equal(t, expected, rr.Body.String())
+}
+
+func TestPrometheusHandler_Histogram(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := storagepkg.NewHistogram([]float64{0.1, 0.5})
+	for _, v := range []float64{0.05, 0.2, 0.3, 1} {
+		h.Observe(v)
+	}
+	storage := &MockStorage{metrics: []storagepkg.Metric{
+		{ID: "Latency", MType: storagepkg.Histogram, Histogram: h, Labels: storagepkg.Labels{"route": "/update"}},
+	}}
+
+	r := gin.New()
+	r.GET("/metrics", PrometheusHandler(storage))
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	expected := `# HELP Latency histogram Latency.
+# TYPE Latency histogram
+Latency_bucket{le="0.1",route="/update"} 1
+Latency_bucket{le="0.5",route="/update"} 3
+Latency_bucket{le="+Inf",route="/update"} 4
+Latency_sum{route="/update"} 1.55
+Latency_count{route="/update"} 4
+`
+	assert.Equal(t, expected, rr.Body.String())
+}
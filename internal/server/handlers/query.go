@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yokitheyo/guardian-metrics/internal/aggregate"
+	storagepkg "github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+// Query parameters of the aggregation endpoint that are not label matchers.
+const (
+	fnParam       = "fn"
+	windowParam   = "window"
+	quantileParam = "q"
+)
+
+const defaultWindow = 5 * time.Minute
+
+type queryResponse struct {
+	historyResponse
+	Fn     string `json:"fn"`
+	Window string `json:"window"`
+	Step   string `json:"step,omitempty"`
+}
+
+// QueryHandler aggregates the history of one series with fn (min, max, avg,
+// sum, rate or percentile with q) over a sliding window, by default five
+// minutes. With a step the aggregation is evaluated every step from from to
+// to, otherwise once at to, at most aggregate.MaxSteps times and no more
+// often than every aggregate.MinStep. A given from
+// may not be older than maxAge, the history retention, unless it is zero. Other
+// query parameters select the series as in HistoryHandler.
+func QueryHandler(storage storagepkg.Storage, maxAge time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		mType := storagepkg.MetricType(c.Param("type"))
+		if mType != storagepkg.Gauge && mType != storagepkg.Counter {
+			c.JSON(http.StatusNotFound, gin.H{"error": "metric not found"})
+			return
+		}
+
+		fnName := c.Query(fnParam)
+		var q float64
+		if fnName == "percentile" {
+			var err error
+			if q, err = strconv.ParseFloat(c.Query(quantileParam), 64); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: %v", quantileParam, err)})
+				return
+			}
+		}
+		fn, err := aggregate.Lookup(fnName, q)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		window, err := parseDurationParam(c.Query(windowParam), defaultWindow)
+		if err != nil || window == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: must be a positive duration", windowParam)})
+			return
+		}
+		step, err := parseDurationParam(c.Query(stepParam), 0)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: %v", stepParam, err)})
+			return
+		}
+		if step > 0 && step < aggregate.MinStep {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: must be at least %s", stepParam, aggregate.MinStep)})
+			return
+		}
+		to, err := parseTimeParam(c.Query(toParam), time.Now())
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: %v", toParam, err)})
+			return
+		}
+		from, err := parseTimeParam(c.Query(fromParam), to.Add(-window))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid %s: %v", fromParam, err)})
+			return
+		}
+		if from.After(to) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is after %s", fromParam, toParam)})
+			return
+		}
+		if c.Query(fromParam) != "" && maxAge > 0 && from.Before(time.Now().Add(-maxAge)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is older than the %s of history kept", fromParam, maxAge)})
+			return
+		}
+		if aggregate.Steps(from, to, step) > aggregate.MaxSteps {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many steps between %s and %s, at most %d are allowed", fromParam, toParam, aggregate.MaxSteps)})
+			return
+		}
+
+		m, err := findSeries(c, storage, mType, fnParam, windowParam, quantileParam, fromParam, toParam, stepParam)
+		if err != nil {
+			c.JSON(findErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		points, err := storage.Range(m, from.Add(-window), to)
+		if err != nil {
+			rangeError(c, err)
+			return
+		}
+
+		resp := queryResponse{
+			historyResponse: newHistoryResponse(m, aggregate.Evaluate(points, fn, from, to, window, step)),
+			Fn:              fnName,
+			Window:          window.String(),
+		}
+		if step > 0 {
+			resp.Step = step.String()
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	storagepkg "github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+func TestQueryHandler_Gin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage := storagepkg.NewMemStorage()
+	for _, v := range []float64{4, 1, 3, 2} {
+		val := v
+		require.NoError(t, storage.UpdateMetric(storagepkg.Metric{
+			ID: "HeapAlloc", MType: storagepkg.Gauge, Value: &val, Labels: storagepkg.Labels{"host": "a"},
+		}))
+	}
+
+	r := gin.New()
+	r.GET("/query/:type/:name", QueryHandler(storage, time.Hour))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	tests := []struct {
+		path     string
+		expected float64
+	}{
+		{path: "/query/gauge/HeapAlloc?fn=max", expected: 4},
+		{path: "/query/gauge/HeapAlloc?fn=min&host=a", expected: 1},
+		{path: "/query/gauge/HeapAlloc?fn=avg&window=1h", expected: 2.5},
+		{path: "/query/gauge/HeapAlloc?fn=sum&window=60", expected: 10},
+		{path: "/query/gauge/HeapAlloc?fn=percentile&q=0.5", expected: 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := get(tt.path)
+			require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+
+			var resp queryResponse
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			assert.Equal(t, "HeapAlloc", resp.ID)
+			require.Len(t, resp.Points, 1)
+			assert.InDelta(t, tt.expected, resp.Points[0].Value, 1e-9)
+		})
+	}
+
+	rr := get("/query/gauge/HeapAlloc?fn=max&window=1m&step=10s")
+	require.Equal(t, http.StatusOK, rr.Code)
+	var resp queryResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "1m0s", resp.Window)
+	assert.Equal(t, "10s", resp.Step)
+	require.NotEmpty(t, resp.Points)
+	assert.Equal(t, 4.0, resp.Points[len(resp.Points)-1].Value)
+
+	assert.Equal(t, http.StatusBadRequest, get("/query/gauge/HeapAlloc").Code, "fn is required")
+	assert.Equal(t, http.StatusBadRequest, get("/query/gauge/HeapAlloc?fn=median").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/query/gauge/HeapAlloc?fn=percentile").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/query/gauge/HeapAlloc?fn=percentile&q=2").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/query/gauge/HeapAlloc?fn=max&window=0").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/query/gauge/HeapAlloc?fn=max&from=2&to=1").Code)
+	assert.Equal(t, http.StatusNotFound, get("/query/gauge/Missing?fn=max").Code)
+
+	now := time.Now().Unix()
+	assert.Equal(t, http.StatusBadRequest, get("/query/gauge/HeapAlloc?fn=max&from=0&step=1s").Code, "from is older than the retention")
+	assert.Equal(t, http.StatusBadRequest, get("/query/gauge/HeapAlloc?fn=max&step=1us").Code, "step is below a millisecond")
+	assert.Equal(t, http.StatusBadRequest, get(fmt.Sprintf("/query/gauge/HeapAlloc?fn=max&from=%d&step=1ms", now-60)).Code, "too many steps")
+	assert.Equal(t, http.StatusOK, get(fmt.Sprintf("/query/gauge/HeapAlloc?fn=max&from=%d&step=1s", now-60)).Code)
+}
+
+func TestQueryHandler_Unsupported(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	val := 1.0
+	storage := &MockStorage{metrics: []storagepkg.Metric{{ID: "HeapAlloc", MType: storagepkg.Gauge, Value: &val}}}
+
+	r := gin.New()
+	r.GET("/query/:type/:name", QueryHandler(storage, time.Hour))
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/query/gauge/HeapAlloc?fn=avg", nil))
+	assert.Equal(t, http.StatusNotImplemented, rr.Code)
+}
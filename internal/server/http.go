@@ -1,23 +1,258 @@
 package server
 
 import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/yokitheyo/guardian-metrics/internal/alerting"
+	"github.com/yokitheyo/guardian-metrics/internal/certs"
+	"github.com/yokitheyo/guardian-metrics/internal/config"
+	"github.com/yokitheyo/guardian-metrics/internal/encryption"
 	handlerpkg "github.com/yokitheyo/guardian-metrics/internal/server/handlers"
 	"github.com/yokitheyo/guardian-metrics/internal/server/middleware"
 	"github.com/yokitheyo/guardian-metrics/internal/storage"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-func RunServer(storage storage.Storage, addr string, logger *zap.Logger) error {
+// shutdownTimeout bounds how long in-flight requests may take to finish once
+// the server is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+// NewRouter serves the HTTP API. Update routes only accept requests whose
+// X-Real-IP is in trusted, unless trusted is nil. With a privateKey update
+// bodies must be encrypted and are decrypted with it.
+func NewRouter(storage storage.Storage, alerts handlerpkg.AlertLister, cfg *config.ServerConfig, trusted *net.IPNet, privateKey *rsa.PrivateKey, logger *zap.Logger) *gin.Engine {
 	r := gin.Default()
 
 	r.Use(middleware.LoggingMiddleware(logger))
+	r.Use(middleware.DecryptMiddleware(privateKey))
+	r.Use(middleware.GzipMiddleware())
+	r.Use(middleware.HashMiddleware(cfg.Key))
+	r.Use(middleware.IdentityMiddleware())
+
+	updates := r.Group("/",
+		middleware.TrustedSubnetMiddleware(trusted),
+		middleware.RequireEncryptionMiddleware(privateKey),
+	)
+	updates.POST("/update/:type/:name/:value", handlerpkg.UpdateMetricHandler(storage))
+	updates.POST("/update/", handlerpkg.UpdateMetricJSONHandler(storage))
+	updates.POST("/updates/", handlerpkg.UpdateMetricsBatchHandler(storage))
 
-	r.POST("/update/:type/:name/:value", handlerpkg.UpdateMetricHandler(storage))
 	r.GET("/value/:type/:name", handlerpkg.GetMetricValueHandler(storage))
+	r.POST("/value/", handlerpkg.GetMetricValueJSONHandler(storage))
+	r.GET("/history/:type/:name", handlerpkg.HistoryHandler(storage))
+	r.GET("/query/:type/:name", handlerpkg.QueryHandler(storage, cfg.HistoryAge))
 	r.GET("/", handlerpkg.ListMetricsHandler(storage))
-	log.Println("starting server on", addr)
-	return r.Run(addr)
+	r.GET("/ping", handlerpkg.PingHandler(storage))
+	r.GET("/metrics", handlerpkg.PrometheusHandler(storage))
+	r.GET("/alerts", handlerpkg.AlertsHandler(alerts))
+
+	return r
+}
+
+// newEvaluator loads the alerting rules named in cfg, if any, and sends
+// notifications to the configured webhook. Rules with absent_for are
+// refused unless s keeps at least absent_for of history.
+func newEvaluator(s storage.Storage, cfg *config.ServerConfig, logger *zap.Logger) (*alerting.Evaluator, error) {
+	var rules []alerting.Rule
+	if cfg.AlertRulesPath != "" {
+		var err error
+		if rules, err = alerting.LoadRules(cfg.AlertRulesPath); err != nil {
+			return nil, err
+		}
+		if len(rules) > 0 && cfg.AlertInterval <= 0 {
+			return nil, errors.New("alert interval must be positive")
+		}
+	}
+	for _, rule := range rules {
+		if rule.AbsentFor <= 0 {
+			continue
+		}
+		if err := checkAbsentHistory(s, cfg, rule.AbsentFor); err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+	}
+
+	var notifier alerting.Notifier
+	if cfg.AlertWebhookURL != "" {
+		notifier = alerting.NewWebhookNotifier(cfg.AlertWebhookURL)
+	}
+	return alerting.NewEvaluator(s, rules, notifier, cfg.AlertInterval, logger), nil
+}
+
+// checkAbsentHistory reports why absence over absentFor cannot be told from
+// the history s keeps, if it cannot.
+func checkAbsentHistory(s storage.Storage, cfg *config.ServerConfig, absentFor time.Duration) error {
+	if _, err := s.Range(storage.Metric{}, time.Time{}, time.Time{}); errors.Is(err, storage.ErrHistoryUnsupported) {
+		return errors.New("absent_for needs a storage that keeps history")
+	}
+	if cfg.HistoryPoints <= 0 {
+		return errors.New("absent_for needs history, which is disabled")
+	}
+	if cfg.HistoryAge > 0 && cfg.HistoryAge < absentFor {
+		return fmt.Errorf("absent_for %s is longer than the %s of history kept", absentFor, cfg.HistoryAge)
+	}
+	return nil
+}
+
+// parseTrustedSubnet parses the trusted subnet CIDR, returning nil when it
+// is not set.
+func parseTrustedSubnet(cidr string) (*net.IPNet, error) {
+	if cidr == "" {
+		return nil, nil
+	}
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted subnet: %w", err)
+	}
+	return subnet, nil
+}
+
+// RunServer serves HTTP, and gRPC when a gRPC address is configured, and
+// evaluates alerting rules until ctx is cancelled, then stops accepting
+// connections and waits for in-flight requests to complete.
+func RunServer(ctx context.Context, storage storage.Storage, cfg *config.ServerConfig, logger *zap.Logger) error {
+	trusted, err := parseTrustedSubnet(cfg.TrustedSubnet)
+	if err != nil {
+		return err
+	}
+
+	var privateKey *rsa.PrivateKey
+	if cfg.CryptoKey != "" {
+		if privateKey, err = encryption.LoadPrivateKey(cfg.CryptoKey); err != nil {
+			return err
+		}
+	}
+
+	var reloader *certs.Reloader
+	if cfg.TLSCert != "" || cfg.TLSKey != "" {
+		if reloader, err = certs.NewReloader(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA); err != nil {
+			return err
+		}
+		go reloadOnHangup(ctx, reloader)
+	} else if cfg.TLSClientCA != "" {
+		return errors.New("client CA requires a server certificate")
+	}
+
+	evaluator, err := newEvaluator(storage, cfg, logger)
+	if err != nil {
+		return err
+	}
+	go evaluator.Run(ctx)
+
+	addr := cfg.Address
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: NewRouter(storage, evaluator, cfg, trusted, privateKey, logger),
+	}
+	if reloader != nil {
+		srv.TLSConfig = reloader.TLSConfig()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Println("starting server on", addr)
+		var err error
+		if reloader != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- err
+		}
+		close(errCh)
+	}()
+
+	var grpcSrv *grpc.Server
+	grpcErrCh := make(chan error, 1)
+	if cfg.GRPCAddress != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddress)
+		if err != nil {
+			srv.Close()
+			return err
+		}
+		var opts []grpc.ServerOption
+		if reloader != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(reloader.TLSConfig())))
+		}
+		grpcSrv = NewGRPCServer(storage, cfg, trusted, logger, opts...)
+		go func() {
+			log.Println("starting gRPC server on", cfg.GRPCAddress)
+			grpcErrCh <- grpcSrv.Serve(lis)
+		}()
+	}
+
+	select {
+	case err := <-errCh:
+		if grpcSrv != nil {
+			grpcSrv.Stop()
+		}
+		return err
+	case err := <-grpcErrCh:
+		srv.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if grpcSrv != nil {
+		stopGRPC(shutdownCtx, grpcSrv)
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return <-errCh
+}
+
+// reloadOnHangup reloads the certificates every time the process receives
+// SIGHUP, until ctx is cancelled.
+func reloadOnHangup(ctx context.Context, reloader *certs.Reloader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := reloader.Reload(); err != nil {
+				log.Printf("failed to reload certificates: %v, keeping the previous ones", err)
+				continue
+			}
+			log.Println("certificates reloaded")
+		}
+	}
+}
+
+// stopGRPC waits for in-flight calls to finish, cancelling those still
+// running once ctx is done.
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		srv.Stop()
+		<-done
+	}
 }
@@ -0,0 +1,36 @@
+package interceptor
+
+import (
+	"context"
+
+	"github.com/yokitheyo/guardian-metrics/internal/pb"
+	"github.com/yokitheyo/guardian-metrics/internal/sign"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// HashUnary checks that the HashSHA256 metadata of every call holds the
+// signature of its request, marshaled with pb.MarshalSigned. With an empty key
+// it does nothing. Streamed messages are signed one by one and checked by
+// the service instead.
+func HashUnary(key string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if key == "" {
+			return handler(ctx, req)
+		}
+		msg, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.Internal, "request is not a protobuf message")
+		}
+		data, err := pb.MarshalSigned(msg)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, "failed to marshal request")
+		}
+		if !sign.Verify(data, key, metadataValue(ctx, sign.Header)) {
+			return nil, status.Error(codes.InvalidArgument, "invalid signature")
+		}
+		return handler(ctx, req)
+	}
+}
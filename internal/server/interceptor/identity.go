@@ -0,0 +1,64 @@
+package interceptor
+
+import (
+	"context"
+	"strings"
+
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// IdentityUnary stores the agent id and tenant sent in the call metadata in
+// the call context, like the HTTP identity middleware does with headers,
+// including the precedence of the client certificate common name.
+func IdentityUnary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(withIdentity(ctx), req)
+	}
+}
+
+// IdentityStream is the streaming counterpart of IdentityUnary.
+func IdentityStream() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &contextStream{ServerStream: ss, ctx: withIdentity(ss.Context())})
+	}
+}
+
+func withIdentity(ctx context.Context) context.Context {
+	id := identity.Identity{
+		Source: metadataValue(ctx, identity.AgentHeader),
+		Tenant: metadataValue(ctx, identity.TenantHeader),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			if cn := identity.CommonName(&info.State); cn != "" {
+				id.Source = cn
+				id.Verified = true
+			}
+		}
+	}
+	return identity.NewContext(ctx, id)
+}
+
+// metadataValue returns the first value of an incoming metadata key. Keys
+// are the lower-cased HTTP header names.
+func metadataValue(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(strings.ToLower(key)); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// contextStream replaces the context of a server stream.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,39 @@
+// Package interceptor holds the gRPC counterparts of the HTTP middleware.
+package interceptor
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// LoggingUnary logs every call with its status and duration.
+func LoggingUnary(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logCall(logger, info.FullMethod, err, time.Since(start))
+		return resp, err
+	}
+}
+
+// LoggingStream logs every stream with its status and duration.
+func LoggingStream(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logCall(logger, info.FullMethod, err, time.Since(start))
+		return err
+	}
+}
+
+func logCall(logger *zap.Logger, method string, err error, duration time.Duration) {
+	logger.Info("gRPC request",
+		zap.String("method", method),
+		zap.String("code", status.Code(err).String()),
+		zap.Duration("duration", duration),
+	)
+}
@@ -0,0 +1,43 @@
+package interceptor
+
+import (
+	"context"
+	"net"
+
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// TrustedSubnetUnary rejects with PermissionDenied calls whose x-real-ip
+// metadata is missing or outside subnet. With a nil subnet it does nothing.
+func TrustedSubnetUnary(subnet *net.IPNet) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkSubnet(ctx, subnet); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// TrustedSubnetStream is the streaming counterpart of TrustedSubnetUnary.
+func TrustedSubnetStream(subnet *net.IPNet) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkSubnet(ss.Context(), subnet); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func checkSubnet(ctx context.Context, subnet *net.IPNet) error {
+	if subnet == nil {
+		return nil
+	}
+	ip := net.ParseIP(metadataValue(ctx, identity.RealIPHeader))
+	if ip == nil || !subnet.Contains(ip) {
+		return status.Error(codes.PermissionDenied, "address is not in the trusted subnet")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yokitheyo/guardian-metrics/internal/encryption"
+)
+
+// maxEncryptedBodySize bounds the encrypted body read into memory.
+const maxEncryptedBodySize = 32 << 20
+
+// decryptedKey marks in the gin context requests whose body was decrypted.
+const decryptedKey = "decrypted"
+
+// DecryptMiddleware replaces the body of requests marked with the
+// X-Encryption header by its plaintext, so the middleware and handlers that
+// follow see the body as sent before encryption. Unmarked requests pass
+// through unchanged; RequireEncryptionMiddleware rejects them where needed.
+func DecryptMiddleware(key *rsa.PrivateKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme := c.GetHeader(encryption.Header)
+		if scheme == "" {
+			c.Next()
+			return
+		}
+		if scheme != encryption.Scheme {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "unsupported encryption scheme"})
+			return
+		}
+		if key == nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "encryption is not configured"})
+			return
+		}
+
+		message, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxEncryptedBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "body is too large"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+			return
+		}
+		body, err := encryption.Decrypt(key, message)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to decrypt body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Request.ContentLength = int64(len(body))
+		c.Request.Header.Del(encryption.Header)
+		c.Set(decryptedKey, true)
+		c.Next()
+	}
+}
+
+// RequireEncryptionMiddleware rejects with 400 requests that
+// DecryptMiddleware did not decrypt, when the server has a private key.
+// With a nil key it does nothing.
+func RequireEncryptionMiddleware(key *rsa.PrivateKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key != nil && !c.GetBool(decryptedKey) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "request body must be encrypted"})
+			return
+		}
+		c.Next()
+	}
+}
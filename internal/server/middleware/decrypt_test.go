@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/encryption"
+)
+
+func TestDecryptMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	plaintext := []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)
+	message, err := encryption.Encrypt(&key.PublicKey, plaintext)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		key        *rsa.PrivateKey
+		scheme     string
+		body       []byte
+		wantStatus int
+		wantBody   []byte
+	}{
+		{name: "encrypted", key: key, scheme: encryption.Scheme, body: message, wantStatus: http.StatusOK, wantBody: plaintext},
+		{name: "plain", key: key, body: plaintext, wantStatus: http.StatusOK, wantBody: plaintext},
+		{name: "corrupted", key: key, scheme: encryption.Scheme, body: plaintext, wantStatus: http.StatusBadRequest},
+		{name: "unknown scheme", key: key, scheme: "rot13", body: message, wantStatus: http.StatusBadRequest},
+		{name: "no key", scheme: encryption.Scheme, body: message, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []byte
+			r := gin.New()
+			r.Use(DecryptMiddleware(tt.key))
+			r.POST("/updates/", func(c *gin.Context) {
+				got, _ = io.ReadAll(c.Request.Body)
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(tt.body))
+			if tt.scheme != "" {
+				req.Header.Set(encryption.Header, tt.scheme)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantBody != nil {
+				assert.Equal(t, tt.wantBody, got)
+			}
+		})
+	}
+}
+
+func TestDecryptMiddleware_BodyTooLarge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	r := gin.New()
+	r.Use(DecryptMiddleware(key))
+	r.POST("/updates/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(make([]byte, maxEncryptedBodySize+1)))
+	req.Header.Set(encryption.Header, encryption.Scheme)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestRequireEncryptionMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	message, err := encryption.Encrypt(&key.PublicKey, []byte("[]"))
+	require.NoError(t, err)
+
+	post := func(key *rsa.PrivateKey, body []byte, encrypted bool) int {
+		r := gin.New()
+		r.Use(DecryptMiddleware(key))
+		r.POST("/updates/", RequireEncryptionMiddleware(key), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		req := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+		if encrypted {
+			req.Header.Set(encryption.Header, encryption.Scheme)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post(key, message, true))
+	assert.Equal(t, http.StatusBadRequest, post(key, []byte("[]"), false), "plaintext with a key configured")
+	assert.Equal(t, http.StatusOK, post(nil, []byte("[]"), false), "plaintext without a key")
+}
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxDecompressedBodySize bounds the decompressed request body, so a small
+// gzip bomb cannot make readers further in hold gigabytes in memory.
+const maxDecompressedBodySize = 32 << 20
+
+// compressibleTypes lists response content types worth compressing.
+var compressibleTypes = []string{
+	"application/json",
+	"text/html",
+}
+
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+// decide inspects the response Content-Type right before the first body byte
+// is written and switches to gzip output when it is worth it.
+func (w *gzipResponseWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	if w.Header().Get("Content-Encoding") != "" || !isCompressible(w.Header().Get("Content-Type")) {
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Del("Content-Length")
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	w.decide()
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// WriteHeaderNow decides on compression before the headers are sent, for
+// callers that commit them ahead of the body.
+func (w *gzipResponseWriter) WriteHeaderNow() {
+	w.decide()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipResponseWriter) close() error {
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
+
+func isCompressible(contentType string) bool {
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// GzipMiddleware transparently decompresses gzip-encoded request bodies, up
+// to maxDecompressedBodySize, and compresses JSON and HTML responses for
+// clients that accept gzip.
+func GzipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if strings.Contains(c.GetHeader("Content-Encoding"), "gzip") {
+			gr, err := gzip.NewReader(c.Request.Body)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid gzip body"})
+				return
+			}
+			defer gr.Close()
+
+			c.Request.Body = http.MaxBytesReader(c.Writer, gr, maxDecompressedBodySize)
+			c.Request.Header.Del("Content-Encoding")
+			c.Request.Header.Del("Content-Length")
+			c.Request.ContentLength = -1
+		}
+
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+			c.Next()
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = gw
+		defer func() {
+			gw.close()
+			c.Writer = gw.ResponseWriter
+		}()
+
+		c.Next()
+	}
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBytes(t *testing.T, data string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func newGzipRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(GzipMiddleware())
+	r.POST("/echo", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"body": string(body)})
+	})
+	r.GET("/html", func(c *gin.Context) {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.String(http.StatusOK, "<html></html>")
+	})
+	r.GET("/text", func(c *gin.Context) {
+		c.String(http.StatusOK, "plain")
+	})
+	return r
+}
+
+func TestGzipMiddleware_DecompressesRequest(t *testing.T) {
+	r := newGzipRouter()
+
+	req := httptest.NewRequest(http.MethodPost, "/echo", bytes.NewReader(gzipBytes(t, "hello")))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.JSONEq(t, `{"body":"hello"}`, w.Body.String())
+}
+
+func TestGzipMiddleware_LimitsDecompressedBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(GzipMiddleware(), HashMiddleware("secret"))
+	r.POST("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(make([]byte, maxDecompressedBodySize+1))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestGzipMiddleware_RejectsBrokenGzip(t *testing.T) {
+	r := newGzipRouter()
+
+	req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("not gzip"))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGzipMiddleware_CompressesResponse(t *testing.T) {
+	r := newGzipRouter()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		compressed bool
+		expected   string
+	}{
+		{name: "json", method: http.MethodPost, path: "/echo", compressed: true, expected: `{"body":""}`},
+		{name: "html", method: http.MethodGet, path: "/html", compressed: true, expected: "<html></html>"},
+		{name: "plain text", method: http.MethodGet, path: "/text", compressed: false, expected: "plain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+
+			body := w.Body.Bytes()
+			if tt.compressed {
+				require.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				require.NoError(t, err)
+				body, err = io.ReadAll(zr)
+				require.NoError(t, err)
+			} else {
+				assert.Empty(t, w.Header().Get("Content-Encoding"))
+			}
+			assert.Equal(t, tt.expected, string(body))
+		})
+	}
+}
+
+func TestGzipMiddleware_NoAcceptEncoding(t *testing.T) {
+	r := newGzipRouter()
+
+	req := httptest.NewRequest(http.MethodGet, "/html", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Empty(t, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "<html></html>", w.Body.String())
+}
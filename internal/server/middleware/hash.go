@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yokitheyo/guardian-metrics/internal/sign"
+)
+
+// signingResponseWriter holds the response body back until the handler is
+// done, so the signature header can be set before anything is sent.
+type signingResponseWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *signingResponseWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *signingResponseWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+func (w *signingResponseWriter) WriteHeaderNow() {}
+
+// HashMiddleware checks the HashSHA256 header of every request that carries
+// a body and signs every response with the same key. With an empty key it
+// does nothing.
+func HashMiddleware(key string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			body, err := io.ReadAll(c.Request.Body)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "body is too large"})
+				return
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+			if !sign.Verify(body, key, c.GetHeader(sign.Header)) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid signature"})
+				return
+			}
+		}
+
+		sw := &signingResponseWriter{
+			ResponseWriter: c.Writer,
+			body:           &bytes.Buffer{},
+		}
+		c.Writer = sw
+
+		c.Next()
+
+		c.Writer = sw.ResponseWriter
+		c.Header(sign.Header, sign.Sum(sw.body.Bytes(), key))
+		// Writing commits the headers, after writers further out such as
+		// gzip have had their say about them.
+		c.Writer.Write(sw.body.Bytes())
+	}
+}
@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/sign"
+)
+
+func newHashRouter(key string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(GzipMiddleware())
+	r.Use(HashMiddleware(key))
+	r.POST("/echo", func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.JSON(http.StatusOK, gin.H{"body": string(body)})
+	})
+	r.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "index")
+	})
+	return r
+}
+
+func TestHashMiddleware(t *testing.T) {
+	const key = "secret"
+	body := `{"id":"Alloc","type":"gauge","value":1}`
+
+	tests := []struct {
+		name           string
+		key            string
+		hash           string
+		expectedStatus int
+	}{
+		{name: "valid signature", key: key, hash: sign.Sum([]byte(body), key), expectedStatus: http.StatusOK},
+		{name: "signed with another key", key: key, hash: sign.Sum([]byte(body), "other"), expectedStatus: http.StatusBadRequest},
+		{name: "missing signature", key: key, hash: "", expectedStatus: http.StatusBadRequest},
+		{name: "no key configured", key: "", hash: "", expectedStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newHashRouter(tt.key)
+
+			req := httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader(body))
+			if tt.hash != "" {
+				req.Header.Set(sign.Header, tt.hash)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == http.StatusOK {
+				assert.Contains(t, w.Body.String(), "Alloc", "handler should still see the request body")
+			}
+			if tt.key == "" {
+				assert.Empty(t, w.Header().Get(sign.Header))
+			}
+		})
+	}
+}
+
+func TestHashMiddleware_SignsResponse(t *testing.T) {
+	const key = "secret"
+	r := newHashRouter(key)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "index", w.Body.String())
+	assert.True(t, sign.Verify(w.Body.Bytes(), key, w.Header().Get(sign.Header)))
+}
+
+func TestHashMiddleware_VerifiesDecompressedBody(t *testing.T) {
+	const key = "secret"
+	r := newHashRouter(key)
+	body := `[{"id":"PollCount","type":"counter","delta":1}]`
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write([]byte(body))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/echo", &buf)
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set(sign.Header, sign.Sum([]byte(body), key))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	// The recorder header map stays writable after the headers are sent,
+	// so check the snapshot taken when they were.
+	sent := w.Result().Header
+	require.Equal(t, "gzip", sent.Get("Content-Encoding"))
+
+	zr, err := gzip.NewReader(w.Body)
+	require.NoError(t, err)
+	resp, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.True(t, sign.Verify(resp, key, sent.Get(sign.Header)), "response signature covers the uncompressed body")
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+)
+
+// IdentityMiddleware stores the agent id and tenant sent in the request
+// headers in the request context for handlers to attribute metrics with.
+// The common name of a verified client certificate takes precedence over
+// the agent header.
+func IdentityMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := identity.Identity{
+			Source: c.GetHeader(identity.AgentHeader),
+			Tenant: c.GetHeader(identity.TenantHeader),
+		}
+		if cn := identity.CommonName(c.Request.TLS); cn != "" {
+			id.Source = cn
+			id.Verified = true
+		}
+		c.Request = c.Request.WithContext(identity.NewContext(c.Request.Context(), id))
+		c.Next()
+	}
+}
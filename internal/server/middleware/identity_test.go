@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+)
+
+func TestIdentityMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var got identity.Identity
+	r := gin.New()
+	r.Use(IdentityMiddleware())
+	r.GET("/", func(c *gin.Context) {
+		got = identity.FromContext(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(identity.AgentHeader, "host-1")
+	req.Header.Set(identity.TenantHeader, "team-a")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, identity.Identity{Source: "host-1", Tenant: "team-a"}, got)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, identity.Identity{}, got)
+}
+
+func TestIdentityMiddleware_ClientCertificate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var got identity.Identity
+	r := gin.New()
+	r.Use(IdentityMiddleware())
+	r.GET("/", func(c *gin.Context) {
+		got = identity.FromContext(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(identity.AgentHeader, "spoofed")
+	req.Header.Set(identity.TenantHeader, "team-a")
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "agent-1"}}}},
+	}
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, identity.Identity{Source: "agent-1", Tenant: "team-a", Verified: true}, got, "the certificate name should override the header")
+}
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+)
+
+// TrustedSubnetMiddleware rejects with 403 requests whose X-Real-IP header
+// is missing or outside subnet. With a nil subnet it does nothing.
+func TrustedSubnetMiddleware(subnet *net.IPNet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if subnet == nil {
+			c.Next()
+			return
+		}
+		ip := net.ParseIP(c.GetHeader(identity.RealIPHeader))
+		if ip == nil || !subnet.Contains(ip) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "address is not in the trusted subnet"})
+			return
+		}
+		c.Next()
+	}
+}
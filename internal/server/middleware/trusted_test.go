@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+)
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, subnet, err := net.ParseCIDR("192.168.1.0/24")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		subnet     *net.IPNet
+		realIP     string
+		wantStatus int
+	}{
+		{name: "inside", subnet: subnet, realIP: "192.168.1.10", wantStatus: http.StatusOK},
+		{name: "outside", subnet: subnet, realIP: "10.0.0.1", wantStatus: http.StatusForbidden},
+		{name: "missing", subnet: subnet, wantStatus: http.StatusForbidden},
+		{name: "malformed", subnet: subnet, realIP: "not-an-ip", wantStatus: http.StatusForbidden},
+		{name: "no subnet", realIP: "10.0.0.1", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := gin.New()
+			r.Use(TrustedSubnetMiddleware(tt.subnet))
+			r.POST("/update/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			if tt.realIP != "" {
+				req.Header.Set(identity.RealIPHeader, tt.realIP)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code)
+		})
+	}
+}
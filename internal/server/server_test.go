@@ -1,16 +1,26 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/agent/sender"
+	"github.com/yokitheyo/guardian-metrics/internal/config"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
 	handlerpkg "github.com/yokitheyo/guardian-metrics/internal/server/handlers"
 	storagepkg "github.com/yokitheyo/guardian-metrics/internal/storage"
+	"go.uber.org/zap"
 )
 
 func TestRunServer_Gin(t *testing.T) {
@@ -36,3 +46,149 @@ func TestRunServer_Gin(t *testing.T) {
 	require.NotNil(t, metrics[0].Delta)
 	assert.Equal(t, int64(42), *metrics[0].Delta)
 }
+
+func TestRunServer_GracefulShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- RunServer(ctx, storagepkg.NewMemStorage(), &config.ServerConfig{Address: addr}, zap.NewNop())
+	}()
+
+	client := &http.Client{Timeout: time.Second}
+	require.Eventually(t, func() bool {
+		resp, err := client.Post("http://"+addr+"/update/counter/testMetric/1", "text/plain", nil)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 2*time.Second, 20*time.Millisecond)
+
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(shutdownTimeout):
+		t.Fatal("RunServer did not return after context cancellation")
+	}
+
+	_, err = client.Get("http://" + addr + "/")
+	assert.Error(t, err, "server should not accept connections after shutdown")
+}
+
+func TestNewRouter_TrustedSubnet(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, trusted, err := net.ParseCIDR("10.0.0.0/8")
+	require.NoError(t, err)
+	r := NewRouter(storagepkg.NewMemStorage(), nil, &config.ServerConfig{}, trusted, nil, zap.NewNop())
+
+	req := httptest.NewRequest(http.MethodPost, "/update/counter/testMetric/1", nil)
+	req.Header.Set(identity.RealIPHeader, "192.168.0.1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/update/counter/testMetric/1", nil)
+	req.Header.Set(identity.RealIPHeader, "10.0.0.5")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/value/counter/testMetric", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "reads are not restricted")
+}
+
+func TestRunServer_InvalidTrustedSubnet(t *testing.T) {
+	err := RunServer(context.Background(), storagepkg.NewMemStorage(), &config.ServerConfig{TrustedSubnet: "10.0.0.0"}, zap.NewNop())
+	assert.Error(t, err)
+}
+
+func TestNewRouter_EncryptedAndSignedUpdates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	storage := storagepkg.NewMemStorage()
+	ts := httptest.NewServer(NewRouter(storage, nil, &config.ServerConfig{Key: "secret"}, nil, key, zap.NewNop()))
+	defer ts.Close()
+
+	s := sender.NewHTTPSender(ts.URL, "secret", nil, identity.Identity{})
+	s.SetPublicKey(&key.PublicKey)
+	require.NoError(t, s.SendMetrics(context.Background(), []storagepkg.Metric{storagepkg.NewCounter("PollCount", 3)}))
+
+	v, ok := storage.GetCounter("PollCount")
+	require.True(t, ok)
+	assert.Equal(t, int64(3), v)
+
+}
+
+func TestNewRouter_RequiresEncryptedUpdates(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	r := NewRouter(storagepkg.NewMemStorage(), nil, &config.ServerConfig{}, nil, key, zap.NewNop())
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/update/counter/PollCount/1", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code, "unencrypted updates are rejected")
+	assert.Contains(t, w.Body.String(), "must be encrypted")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "reads need no encryption")
+}
+
+// noHistoryStorage stands in for a storage that keeps no history.
+type noHistoryStorage struct {
+	storagepkg.Storage
+}
+
+func (noHistoryStorage) Range(storagepkg.Metric, time.Time, time.Time) ([]storagepkg.Point, error) {
+	return nil, storagepkg.ErrHistoryUnsupported
+}
+
+func TestNewEvaluator_AbsentNeedsHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - name: AgentDown
+    metric: PollCount
+    type: counter
+    absent_for: 5m
+`), 0o600))
+	mem := storagepkg.NewMemStorage()
+
+	tests := []struct {
+		name    string
+		storage storagepkg.Storage
+		cfg     config.ServerConfig
+		wantErr bool
+	}{
+		{"history kept", mem, config.ServerConfig{HistoryPoints: 10, HistoryAge: time.Hour}, false},
+		{"history kept forever", mem, config.ServerConfig{HistoryPoints: 10}, false},
+		{"history disabled", mem, config.ServerConfig{HistoryPoints: 0, HistoryAge: time.Hour}, true},
+		{"history too short", mem, config.ServerConfig{HistoryPoints: 10, HistoryAge: time.Minute}, true},
+		{"storage without history", noHistoryStorage{mem}, config.ServerConfig{HistoryPoints: 10, HistoryAge: time.Hour}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.AlertRulesPath = path
+			tt.cfg.AlertInterval = time.Second
+			_, err := newEvaluator(tt.storage, &tt.cfg, zap.NewNop())
+			if tt.wantErr {
+				assert.ErrorContains(t, err, `rule "AgentDown"`)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
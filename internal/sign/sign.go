@@ -0,0 +1,29 @@
+package sign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Header carries the hex encoded HMAC-SHA256 of the request or response body.
+const Header = "HashSHA256"
+
+// Sum returns the hex encoded HMAC-SHA256 of data keyed with key.
+func Sum(data []byte, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether sum is a valid signature of data, comparing in
+// constant time.
+func Verify(data []byte, key, sum string) bool {
+	expected, err := hex.DecodeString(sum)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
@@ -0,0 +1,21 @@
+package sign
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSumAndVerify(t *testing.T) {
+	data := []byte(`[{"id":"Alloc","type":"gauge","value":1}]`)
+
+	sum := Sum(data, "secret")
+	assert.Len(t, sum, 64)
+	assert.Equal(t, sum, Sum(data, "secret"), "signature should be deterministic")
+
+	assert.True(t, Verify(data, "secret", sum))
+	assert.False(t, Verify(data, "other", sum), "wrong key")
+	assert.False(t, Verify([]byte("tampered"), "secret", sum), "tampered body")
+	assert.False(t, Verify(data, "secret", "not-hex"), "malformed signature")
+	assert.False(t, Verify(data, "secret", ""), "missing signature")
+}
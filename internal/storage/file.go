@@ -1 +1,178 @@
 package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yokitheyo/guardian-metrics/internal/retry"
+)
+
+// FileStorage keeps metrics in memory and dumps them to a JSON file either
+// periodically or, when the interval is zero, after every update.
+type FileStorage struct {
+	*MemStorage
+	path        string
+	interval    time.Duration
+	retryDelays []time.Duration
+
+	fileMu    sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewFileStorage(path string, interval time.Duration, restore bool) (*FileStorage, error) {
+	s := &FileStorage{
+		MemStorage:  NewMemStorage(),
+		path:        path,
+		interval:    interval,
+		retryDelays: retry.DefaultDelays,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	if restore {
+		if err := s.Load(); err != nil {
+			return nil, fmt.Errorf("failed to restore metrics from %s: %w", path, err)
+		}
+	}
+
+	if interval > 0 {
+		go s.flushLoop()
+	} else {
+		close(s.done)
+	}
+
+	return s, nil
+}
+
+func (s *FileStorage) UpdateMetric(m Metric) error {
+	if err := s.MemStorage.UpdateMetric(m); err != nil {
+		return err
+	}
+	return s.syncSave()
+}
+
+func (s *FileStorage) UpdateMetrics(metrics []Metric) error {
+	if err := s.MemStorage.UpdateMetrics(metrics); err != nil {
+		return err
+	}
+	return s.syncSave()
+}
+
+// syncSave saves after an update when there is no periodic flush. The
+// update is already applied in memory, so a failed save is only logged:
+// reporting it would make the sender retry and apply the update twice. The
+// next save writes it out.
+func (s *FileStorage) syncSave() error {
+	if s.interval > 0 {
+		return nil
+	}
+	if err := s.Save(); err != nil {
+		log.Printf("failed to save metrics: %v", err)
+	}
+	return nil
+}
+
+func (s *FileStorage) flushLoop() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				log.Printf("failed to save metrics: %v", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// isRetriableFileError reports whether a file operation failed for a
+// transient reason, such as the file being locked by another process.
+func isRetriableFileError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.EINTR)
+}
+
+// Save writes a snapshot of all metrics to the file. The snapshot is written
+// to a temporary file first so a crash never leaves a truncated dump behind.
+// Each attempt takes the snapshot under the file lock, so concurrent saves
+// never write an older snapshot over a newer one.
+func (s *FileStorage) Save() error {
+	return retry.Do(context.Background(), s.retryDelays, isRetriableFileError, func() error {
+		s.fileMu.Lock()
+		defer s.fileMu.Unlock()
+
+		data, err := json.MarshalIndent(s.GetAll(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal metrics: %w", err)
+		}
+		return s.write(data)
+	})
+}
+
+// write replaces the file with data. The caller holds fileMu.
+func (s *FileStorage) write(data []byte) error {
+	if dir := filepath.Dir(s.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	return nil
+}
+
+// Load reads metrics previously written by Save. A missing or empty file is
+// not an error: the storage simply starts empty.
+func (s *FileStorage) Load() error {
+	s.fileMu.Lock()
+	data, err := os.ReadFile(s.path)
+	s.fileMu.Unlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var metrics []Metric
+	if err := json.Unmarshal(data, &metrics); err != nil {
+		return fmt.Errorf("failed to unmarshal metrics: %w", err)
+	}
+
+	s.MemStorage.restore(metrics)
+	return nil
+}
+
+// Close stops periodic flushing and writes a final snapshot.
+func (s *FileStorage) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+	return s.Save()
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorage_SyncSaveAndRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	s, err := NewFileStorage(path, 0, false)
+	require.NoError(t, err)
+
+	g := 3.25
+	d := int64(5)
+	require.NoError(t, s.UpdateMetric(Metric{ID: "g", MType: Gauge, Value: &g}))
+	require.NoError(t, s.UpdateMetric(Metric{ID: "g", MType: Gauge, Value: &g, Labels: Labels{"host": "a"}}))
+	require.NoError(t, s.UpdateMetrics([]Metric{
+		{ID: "c", MType: Counter, Delta: &d},
+		{ID: "c", MType: Counter, Delta: &d},
+	}))
+
+	_, err = os.Stat(path)
+	require.NoError(t, err, "synchronous mode should write the file on every update")
+
+	restored, err := NewFileStorage(path, 0, true)
+	require.NoError(t, err)
+
+	val, ok := restored.GetGauge("g")
+	require.True(t, ok)
+	assert.Equal(t, 3.25, val)
+
+	cnt, ok := restored.GetCounter("c")
+	require.True(t, ok)
+	assert.Equal(t, int64(10), cnt)
+
+	labeled := restored.Query(Selector{ID: "g", Matchers: Labels{"host": "a"}})
+	require.Len(t, labeled, 1)
+	assert.Equal(t, Labels{"host": "a"}, labeled[0].Labels)
+}
+
+func TestFileStorage_SyncSaveFailureKeepsUpdate(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	require.NoError(t, os.WriteFile(blocker, nil, 0o644))
+	path := filepath.Join(blocker, "metrics.json")
+
+	s, err := NewFileStorage(path, 0, false)
+	require.NoError(t, err)
+
+	d := int64(5)
+	require.NoError(t, s.UpdateMetric(Metric{ID: "c", MType: Counter, Delta: &d}),
+		"the update is applied, so a failed save must not ask for a retry")
+	require.Error(t, s.Save())
+
+	cnt, ok := s.GetCounter("c")
+	require.True(t, ok)
+	assert.Equal(t, int64(5), cnt)
+}
+
+func TestFileStorage_ConcurrentSyncSaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	s, err := NewFileStorage(path, 0, false)
+	require.NoError(t, err)
+
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := int64(1)
+			assert.NoError(t, s.UpdateMetric(Metric{ID: "c", MType: Counter, Delta: &d}))
+		}()
+	}
+	wg.Wait()
+
+	restored, err := NewFileStorage(path, 0, true)
+	require.NoError(t, err)
+	cnt, ok := restored.GetCounter("c")
+	require.True(t, ok)
+	assert.Equal(t, int64(writers), cnt, "the last save should hold every update")
+}
+
+func TestFileStorage_RestoresHistograms(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	s, err := NewFileStorage(path, 0, false)
+	require.NoError(t, err)
+
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.5)
+	require.NoError(t, s.UpdateMetric(Metric{ID: "Latency", MType: Histogram, Histogram: h}))
+	require.NoError(t, s.UpdateMetric(Metric{ID: "Latency", MType: Histogram, Histogram: h}))
+
+	restored, err := NewFileStorage(path, 0, true)
+	require.NoError(t, err)
+
+	m, err := FindOne(restored, Selector{MType: Histogram, ID: "Latency"})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), m.Histogram.Count, "restoring sets the histogram instead of merging")
+	assert.Equal(t, []uint64{0, 2, 0}, m.Histogram.Counts)
+}
+
+func TestFileStorage_RestoreDisabled(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"id":"g","type":"gauge","value":1}]`), 0o644))
+
+	s, err := NewFileStorage(path, 0, false)
+	require.NoError(t, err)
+	assert.Empty(t, s.GetAll())
+}
+
+func TestFileStorage_RestoreMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.json")
+
+	s, err := NewFileStorage(path, 0, true)
+	require.NoError(t, err)
+	assert.Empty(t, s.GetAll())
+}
+
+func TestFileStorage_PeriodicSaveAndClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.json")
+
+	s, err := NewFileStorage(path, time.Hour, false)
+	require.NoError(t, err)
+
+	g := 7.0
+	require.NoError(t, s.UpdateMetric(Metric{ID: "g", MType: Gauge, Value: &g}))
+
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist, "periodic mode should not write on update")
+
+	require.NoError(t, s.Close())
+
+	restored, err := NewFileStorage(path, 0, true)
+	require.NoError(t, err)
+	val, ok := restored.GetGauge("g")
+	require.True(t, ok)
+	assert.Equal(t, 7.0, val)
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"errors"
+	"math"
+	"slices"
+	"sort"
+)
+
+var (
+	ErrMissingHistogram = errors.New("histogram is missing")
+	ErrInvalidBuckets   = errors.New("histogram buckets must be finite and strictly increasing")
+	ErrInvalidCounts    = errors.New("histogram needs one count per bucket plus one for +Inf, adding up to count")
+	ErrBucketMismatch   = errors.New("histogram buckets differ from the stored ones")
+	ErrInvalidSum       = errors.New("histogram sum must be finite")
+)
+
+// DefaultBuckets suit latencies measured in seconds.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// HistogramValue is a distribution of observations. Counts holds the
+// number of observations in each bucket, not cumulative, where bucket i
+// takes values up to Buckets[i] and the last count takes the rest up to
+// +Inf.
+type HistogramValue struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+// NewHistogram returns an empty histogram with the given bucket upper
+// bounds.
+func NewHistogram(buckets []float64) *HistogramValue {
+	return &HistogramValue{
+		Buckets: slices.Clone(buckets),
+		Counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe adds one observation.
+func (h *HistogramValue) Observe(v float64) {
+	i := sort.SearchFloat64s(h.Buckets, v)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += v
+}
+
+// Validate checks the buckets and counts. A NaN or infinite observation
+// leaves a sum that is not finite, which is rejected too since merging
+// would carry it into the stored histogram for good.
+func (h *HistogramValue) Validate() error {
+	for i, b := range h.Buckets {
+		if math.IsInf(b, 0) || math.IsNaN(b) || (i > 0 && b <= h.Buckets[i-1]) {
+			return ErrInvalidBuckets
+		}
+	}
+	if len(h.Counts) != len(h.Buckets)+1 {
+		return ErrInvalidCounts
+	}
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return ErrInvalidCounts
+	}
+	if math.IsInf(h.Sum, 0) || math.IsNaN(h.Sum) {
+		return ErrInvalidSum
+	}
+	return nil
+}
+
+// Merge adds the observations of other, which must have the same buckets.
+func (h *HistogramValue) Merge(other *HistogramValue) error {
+	if !slices.Equal(h.Buckets, other.Buckets) {
+		return ErrBucketMismatch
+	}
+	for i, c := range other.Counts {
+		h.Counts[i] += c
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+	return nil
+}
+
+// Cumulative returns the number of observations up to each bucket bound,
+// ending with the +Inf bucket.
+func (h *HistogramValue) Cumulative() []uint64 {
+	result := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, c := range h.Counts {
+		total += c
+		result[i] = total
+	}
+	return result
+}
+
+func (h *HistogramValue) Clone() *HistogramValue {
+	if h == nil {
+		return nil
+	}
+	return &HistogramValue{
+		Buckets: slices.Clone(h.Buckets),
+		Counts:  slices.Clone(h.Counts),
+		Count:   h.Count,
+		Sum:     h.Sum,
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram_Observe(t *testing.T) {
+	h := NewHistogram([]float64{0.1, 0.5, 1})
+	for _, v := range []float64{0.05, 0.1, 0.3, 0.7, 3} {
+		h.Observe(v)
+	}
+
+	assert.Equal(t, []uint64{2, 1, 1, 1}, h.Counts, "bucket bounds are inclusive")
+	assert.Equal(t, []uint64{2, 3, 4, 5}, h.Cumulative())
+	assert.Equal(t, uint64(5), h.Count)
+	assert.InDelta(t, 4.15, h.Sum, 1e-9)
+	assert.NoError(t, h.Validate())
+}
+
+func TestHistogram_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		h        HistogramValue
+		expected error
+	}{
+		{name: "valid", h: HistogramValue{Buckets: []float64{1, 2}, Counts: []uint64{1, 0, 2}, Count: 3}},
+		{name: "no buckets", h: HistogramValue{Counts: []uint64{2}, Count: 2}},
+		{name: "unsorted buckets", h: HistogramValue{Buckets: []float64{2, 1}, Counts: []uint64{0, 0, 0}}, expected: ErrInvalidBuckets},
+		{name: "duplicate bucket", h: HistogramValue{Buckets: []float64{1, 1}, Counts: []uint64{0, 0, 0}}, expected: ErrInvalidBuckets},
+		{name: "infinite bucket", h: HistogramValue{Buckets: []float64{1, math.Inf(1)}, Counts: []uint64{0, 0, 0}}, expected: ErrInvalidBuckets},
+		{name: "missing +Inf count", h: HistogramValue{Buckets: []float64{1}, Counts: []uint64{0}}, expected: ErrInvalidCounts},
+		{name: "count mismatch", h: HistogramValue{Buckets: []float64{1}, Counts: []uint64{1, 1}, Count: 3}, expected: ErrInvalidCounts},
+		{name: "NaN sum", h: HistogramValue{Buckets: []float64{1}, Counts: []uint64{0, 1}, Count: 1, Sum: math.NaN()}, expected: ErrInvalidSum},
+		{name: "infinite sum", h: HistogramValue{Buckets: []float64{1}, Counts: []uint64{0, 1}, Count: 1, Sum: math.Inf(1)}, expected: ErrInvalidSum},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.h.Validate()
+			if tt.expected == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.expected)
+		})
+	}
+}
+
+func TestHistogram_Merge(t *testing.T) {
+	a := NewHistogram([]float64{1})
+	a.Observe(0.5)
+	b := NewHistogram([]float64{1})
+	b.Observe(2)
+
+	require.NoError(t, a.Merge(b))
+	assert.Equal(t, []uint64{1, 1}, a.Counts)
+	assert.Equal(t, uint64(2), a.Count)
+	assert.Equal(t, 2.5, a.Sum)
+
+	assert.ErrorIs(t, a.Merge(NewHistogram([]float64{2})), ErrBucketMismatch)
+}
+
+func TestMetric_ValidateHistogram(t *testing.T) {
+	assert.ErrorIs(t, Metric{ID: "Latency", MType: Histogram}.Validate(), ErrMissingHistogram)
+	assert.NoError(t, Metric{ID: "Latency", MType: Histogram, Histogram: NewHistogram(DefaultBuckets)}.Validate())
+}
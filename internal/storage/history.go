@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrHistoryUnsupported is returned by storages that keep no history.
+var ErrHistoryUnsupported = errors.New("history is not supported by this storage")
+
+// DefaultRetention keeps an hour of history, at most 720 points per series.
+var DefaultRetention = Retention{MaxPoints: 720, MaxAge: time.Hour}
+
+// Retention bounds the history kept per series. Points beyond MaxPoints or
+// older than MaxAge are dropped; a zero MaxAge keeps points until they are
+// pushed out, and a zero MaxPoints disables history.
+type Retention struct {
+	MaxPoints int
+	MaxAge    time.Duration
+}
+
+// Point is a series value at a moment in time. Counter points hold the
+// running total.
+type Point struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// historyKey tells apart a gauge and a counter sharing a series key.
+type historyKey struct {
+	mtype MetricType
+	seriesKey
+}
+
+// ring is a fixed capacity buffer of points in time order, overwriting the
+// oldest point once full.
+type ring struct {
+	points []Point
+	start  int
+	size   int
+}
+
+func newRing(capacity int) *ring {
+	return &ring{points: make([]Point, capacity)}
+}
+
+func (r *ring) push(p Point) {
+	r.points[(r.start+r.size)%len(r.points)] = p
+	if r.size < len(r.points) {
+		r.size++
+	} else {
+		r.start = (r.start + 1) % len(r.points)
+	}
+}
+
+func (r *ring) at(i int) Point {
+	return r.points[(r.start+i)%len(r.points)]
+}
+
+// dropBefore removes the points older than t.
+func (r *ring) dropBefore(t time.Time) {
+	for r.size > 0 && r.at(0).Timestamp.Before(t) {
+		r.start = (r.start + 1) % len(r.points)
+		r.size--
+	}
+}
+
+// between returns a copy of the points within [from, to].
+func (r *ring) between(from, to time.Time) []Point {
+	var result []Point
+	for i := 0; i < r.size; i++ {
+		p := r.at(i)
+		if p.Timestamp.Before(from) || p.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// Downsample keeps the last point of every step wide window, aligned to the
+// zero time. A non-positive step returns points unchanged.
+func Downsample(points []Point, step time.Duration) []Point {
+	if step <= 0 || len(points) == 0 {
+		return points
+	}
+	var result []Point
+	for _, p := range points {
+		bucket := p.Timestamp.Truncate(step)
+		if n := len(result); n > 0 && result[n-1].Timestamp.Truncate(step).Equal(bucket) {
+			result[n-1] = p
+			continue
+		}
+		result = append(result, p)
+	}
+	return result
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newClockedMemStorage returns a storage whose clock is advanced by tick.
+func newClockedMemStorage(r Retention) (*MemStorage, func(time.Duration)) {
+	s := NewMemStorage()
+	s.SetRetention(r)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, func(d time.Duration) { now = now.Add(d) }
+}
+
+func values(points []Point) []float64 {
+	result := make([]float64, 0, len(points))
+	for _, p := range points {
+		result = append(result, p.Value)
+	}
+	return result
+}
+
+func TestMemStorage_RangeKeepsMaxPoints(t *testing.T) {
+	s, tick := newClockedMemStorage(Retention{MaxPoints: 3})
+
+	for i := 1; i <= 5; i++ {
+		v := float64(i)
+		require.NoError(t, s.UpdateMetric(Metric{ID: "Alloc", MType: Gauge, Value: &v}))
+		tick(time.Second)
+	}
+
+	points, err := s.Range(Metric{ID: "Alloc", MType: Gauge}, time.Time{}, s.now())
+	require.NoError(t, err)
+	assert.Equal(t, []float64{3, 4, 5}, values(points))
+	assert.True(t, points[0].Timestamp.Before(points[1].Timestamp))
+}
+
+func TestMemStorage_RangeDropsOldPoints(t *testing.T) {
+	s, tick := newClockedMemStorage(Retention{MaxPoints: 10, MaxAge: time.Minute})
+
+	d := int64(2)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, s.UpdateMetric(Metric{ID: "PollCount", MType: Counter, Delta: &d}))
+		tick(30 * time.Second)
+	}
+
+	points, err := s.Range(Metric{ID: "PollCount", MType: Counter}, time.Time{}, s.now())
+	require.NoError(t, err)
+	assert.Equal(t, []float64{6, 8}, values(points), "counter points hold the running total")
+
+	start := points[1].Timestamp
+	points, err = s.Range(Metric{ID: "PollCount", MType: Counter}, start, start)
+	require.NoError(t, err)
+	assert.Equal(t, []float64{8}, values(points))
+
+	points, err = s.Range(Metric{ID: "PollCount", MType: Gauge}, time.Time{}, s.now())
+	require.NoError(t, err)
+	assert.Empty(t, points, "a gauge and a counter have separate history")
+}
+
+func TestMemStorage_RangeSeparatesSeries(t *testing.T) {
+	s, _ := newClockedMemStorage(DefaultRetention)
+
+	a, b := 1.0, 2.0
+	require.NoError(t, s.UpdateMetrics([]Metric{
+		{ID: "Alloc", MType: Gauge, Value: &a, Labels: Labels{"host": "a"}},
+		{ID: "Alloc", MType: Gauge, Value: &b, Source: "host-2"},
+	}))
+
+	points, err := s.Range(Metric{ID: "Alloc", MType: Gauge, Labels: Labels{"host": "a"}}, time.Time{}, s.now())
+	require.NoError(t, err)
+	assert.Equal(t, []float64{1}, values(points))
+
+	points, err = s.Range(Metric{ID: "Alloc", MType: Gauge, Source: "host-2"}, time.Time{}, s.now())
+	require.NoError(t, err)
+	assert.Equal(t, []float64{2}, values(points))
+}
+
+func TestMemStorage_HistoryDisabled(t *testing.T) {
+	s, _ := newClockedMemStorage(Retention{})
+
+	v := 1.0
+	require.NoError(t, s.UpdateMetric(Metric{ID: "Alloc", MType: Gauge, Value: &v}))
+
+	points, err := s.Range(Metric{ID: "Alloc", MType: Gauge}, time.Time{}, s.now())
+	require.NoError(t, err)
+	assert.Empty(t, points)
+}
+
+func TestDownsample(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var points []Point
+	for i := 0; i < 6; i++ {
+		points = append(points, Point{Timestamp: start.Add(time.Duration(i) * 10 * time.Second), Value: float64(i)})
+	}
+
+	assert.Equal(t, []float64{2, 5}, values(Downsample(points, 30*time.Second)))
+	assert.Equal(t, points, Downsample(points, 0))
+	assert.Empty(t, Downsample(nil, time.Second))
+}
@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrInvalidLabel = errors.New("invalid label name")
+
+// Labels are optional dimensions of a metric, such as host or service. Two
+// metrics with the same id and type but different labels are separate
+// series.
+type Labels map[string]string
+
+// Validate checks that every label name matches [a-zA-Z_][a-zA-Z0-9_]*.
+func (l Labels) Validate() error {
+	for name := range l {
+		if !isLabelName(name) {
+			return fmt.Errorf("%w: %q", ErrInvalidLabel, name)
+		}
+	}
+	return nil
+}
+
+func isLabelName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
+
+// Key returns a canonical encoding of the label set usable as a map key or a
+// database column: empty for no labels, otherwise JSON with sorted names.
+func (l Labels) Key() string {
+	if len(l) == 0 {
+		return ""
+	}
+	// encoding/json sorts map keys, which makes the output canonical.
+	data, _ := json.Marshal(map[string]string(l))
+	return string(data)
+}
+
+// parseLabelsKey is the inverse of Labels.Key.
+func parseLabelsKey(key string) (Labels, error) {
+	if key == "" {
+		return nil, nil
+	}
+	var l Labels
+	if err := json.Unmarshal([]byte(key), &l); err != nil {
+		return nil, fmt.Errorf("invalid labels %q: %w", key, err)
+	}
+	return l, nil
+}
+
+// Matches reports whether l contains every name/value pair of matchers.
+// Empty matchers match any label set.
+func (l Labels) Matches(matchers Labels) bool {
+	for name, value := range matchers {
+		if v, ok := l[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Clone returns a copy of l, or nil for an empty set.
+func (l Labels) Clone() Labels {
+	if len(l) == 0 {
+		return nil
+	}
+	copied := make(Labels, len(l))
+	for k, v := range l {
+		copied[k] = v
+	}
+	return copied
+}
+
+// Names returns the label names in sorted order.
+func (l Labels) Names() []string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Selector picks series by type, id, source and label matchers, where empty
+// fields match anything. Tenant is always matched exactly so one tenant
+// never sees another's series; the empty tenant is the default namespace.
+type Selector struct {
+	MType    MetricType
+	ID       string
+	Matchers Labels
+	Source   string
+	Tenant   string
+}
+
+func (sel Selector) Matches(m Metric) bool {
+	if sel.Tenant != m.Tenant {
+		return false
+	}
+	if sel.Source != "" && sel.Source != m.Source {
+		return false
+	}
+	if sel.MType != "" && sel.MType != m.MType {
+		return false
+	}
+	if sel.ID != "" && sel.ID != m.ID {
+		return false
+	}
+	return m.Labels.Matches(sel.Matchers)
+}
+
+var (
+	ErrNotFound  = errors.New("metric not found")
+	ErrAmbiguous = errors.New("label matchers select more than one series")
+)
+
+// FindOne resolves sel to a single series. When several series match, the
+// one whose label set equals the matchers and whose source equals the
+// selector's exactly wins; otherwise the selection is ambiguous.
+func FindOne(s Storage, sel Selector) (Metric, error) {
+	matched := s.Query(sel)
+	switch len(matched) {
+	case 0:
+		return Metric{}, ErrNotFound
+	case 1:
+		return matched[0], nil
+	}
+
+	want := sel.Matchers.Key()
+	for _, m := range matched {
+		if m.Labels.Key() == want && m.Source == sel.Source {
+			return m, nil
+		}
+	}
+	return Metric{}, ErrAmbiguous
+}
+
+// sortMetrics orders metrics by id, type, tenant, source and labels so
+// listings are stable.
+func sortMetrics(metrics []Metric) {
+	sort.Slice(metrics, func(i, j int) bool {
+		a, b := metrics[i], metrics[j]
+		if a.ID != b.ID {
+			return a.ID < b.ID
+		}
+		if a.MType != b.MType {
+			return a.MType < b.MType
+		}
+		if a.Tenant != b.Tenant {
+			return a.Tenant < b.Tenant
+		}
+		if a.Source != b.Source {
+			return a.Source < b.Source
+		}
+		return a.Labels.Key() < b.Labels.Key()
+	})
+}
@@ -1,67 +1,309 @@
 package storage
 
 import (
-	"errors"
+	"fmt"
+	"slices"
 	"sync"
+	"time"
 )
 
+// seriesKey identifies one series: a metric id plus its canonical label set
+// within a tenant and source.
+type seriesKey struct {
+	tenant string
+	source string
+	id     string
+	labels string
+}
+
+func keyOf(m Metric) seriesKey {
+	return seriesKey{tenant: m.Tenant, source: m.Source, id: m.ID, labels: m.Labels.Key()}
+}
+
+func (s *MemStorage) gaugeMetric(key seriesKey, val float64) Metric {
+	return Metric{
+		ID: key.id, MType: Gauge, Value: &val,
+		Labels: s.labelSets[key.labels].Clone(), Source: key.source, Tenant: key.tenant,
+	}
+}
+
+func (s *MemStorage) counterMetric(key seriesKey, delta int64) Metric {
+	return Metric{
+		ID: key.id, MType: Counter, Delta: &delta,
+		Labels: s.labelSets[key.labels].Clone(), Source: key.source, Tenant: key.tenant,
+	}
+}
+
+func (s *MemStorage) histogramMetric(key seriesKey, h *HistogramValue) Metric {
+	return Metric{
+		ID: key.id, MType: Histogram, Histogram: h.Clone(),
+		Labels: s.labelSets[key.labels].Clone(), Source: key.source, Tenant: key.tenant,
+	}
+}
+
 type MemStorage struct {
-	mu       sync.RWMutex
-	gauges   map[string]float64
-	counters map[string]int64
+	mu         sync.RWMutex
+	gauges     map[seriesKey]float64
+	counters   map[seriesKey]int64
+	histograms map[seriesKey]*HistogramValue
+	// labelSets maps a canonical label key back to the label set, so each
+	// distinct set is kept once however many series share it.
+	labelSets map[string]Labels
+
+	retention Retention
+	history   map[historyKey]*ring
+	now       func() time.Time
 }
 
 func NewMemStorage() *MemStorage {
 	return &MemStorage{
-		gauges:   make(map[string]float64),
-		counters: make(map[string]int64),
+		gauges:     make(map[seriesKey]float64),
+		counters:   make(map[seriesKey]int64),
+		histograms: make(map[seriesKey]*HistogramValue),
+		labelSets:  make(map[string]Labels),
+		retention:  DefaultRetention,
+		history:    make(map[historyKey]*ring),
+		now:        time.Now,
 	}
 }
 
+// SetRetention changes how much history is kept per series and drops the
+// history recorded so far.
+func (s *MemStorage) SetRetention(r Retention) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retention = r
+	s.history = make(map[historyKey]*ring)
+}
+
 func (s *MemStorage) UpdateMetric(m Metric) error {
+	if err := m.Labels.Validate(); err != nil {
+		return err
+	}
+	if m.Histogram != nil {
+		if err := m.Histogram.Validate(); err != nil {
+			return err
+		}
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	return s.apply(m)
+}
+
+func (s *MemStorage) UpdateMetrics(metrics []Metric) error {
+	for _, m := range metrics {
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("metric %q: %w", m.ID, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkBuckets(metrics); err != nil {
+		return err
+	}
+	for _, m := range metrics {
+		if err := s.apply(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBuckets must be called with s.mu held. It makes sure every histogram
+// in the batch can be merged, so a batch is never applied halfway.
+func (s *MemStorage) checkBuckets(metrics []Metric) error {
+	buckets := make(map[seriesKey][]float64)
+	for _, m := range metrics {
+		if m.MType != Histogram {
+			continue
+		}
+		key := keyOf(m)
+		want, ok := buckets[key]
+		if !ok {
+			stored, exists := s.histograms[key]
+			if !exists {
+				buckets[key] = m.Histogram.Buckets
+				continue
+			}
+			want = stored.Buckets
+			buckets[key] = want
+		}
+		if !slices.Equal(want, m.Histogram.Buckets) {
+			return fmt.Errorf("metric %q: %w", m.ID, ErrBucketMismatch)
+		}
+	}
+	return nil
+}
+
+// apply must be called with s.mu held.
+func (s *MemStorage) apply(m Metric) error {
+	key := keyOf(m)
 	switch m.MType {
 	case Gauge:
 		if m.Value != nil {
-			s.gauges[m.ID] = *m.Value
+			s.gauges[key] = *m.Value
+			s.record(historyKey{Gauge, key}, s.gauges[key])
 		}
 	case Counter:
 		if m.Delta != nil {
-			s.counters[m.ID] += *m.Delta
+			s.counters[key] += *m.Delta
+			s.record(historyKey{Counter, key}, float64(s.counters[key]))
+		}
+	case Histogram:
+		if m.Histogram != nil {
+			stored, ok := s.histograms[key]
+			if !ok {
+				s.histograms[key] = m.Histogram.Clone()
+				break
+			}
+			if err := stored.Merge(m.Histogram); err != nil {
+				return fmt.Errorf("metric %q: %w", m.ID, err)
+			}
 		}
 	default:
-		return errors.New("invalid metric type")
+		return ErrInvalidType
 	}
+	s.internLabels(key.labels, m.Labels)
 	return nil
 }
 
+// record must be called with s.mu held.
+func (s *MemStorage) record(key historyKey, value float64) {
+	if s.retention.MaxPoints <= 0 {
+		return
+	}
+	r, ok := s.history[key]
+	if !ok {
+		r = newRing(s.retention.MaxPoints)
+		s.history[key] = r
+	}
+	now := s.now()
+	if s.retention.MaxAge > 0 {
+		r.dropBefore(now.Add(-s.retention.MaxAge))
+	}
+	r.push(Point{Timestamp: now, Value: value})
+}
+
+// internLabels must be called with s.mu held. It keeps a private copy so
+// callers may reuse their map.
+func (s *MemStorage) internLabels(key string, labels Labels) {
+	if key == "" {
+		return
+	}
+	if _, ok := s.labelSets[key]; !ok {
+		s.labelSets[key] = labels.Clone()
+	}
+}
+
+// restore replaces stored values with the given snapshot, so counters are set
+// rather than incremented.
+func (s *MemStorage) restore(metrics []Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, m := range metrics {
+		key := keyOf(m)
+		switch m.MType {
+		case Gauge:
+			if m.Value != nil {
+				s.gauges[key] = *m.Value
+			}
+		case Counter:
+			if m.Delta != nil {
+				s.counters[key] = *m.Delta
+			}
+		case Histogram:
+			if m.Histogram != nil {
+				s.histograms[key] = m.Histogram.Clone()
+			}
+		default:
+			continue
+		}
+		s.internLabels(key.labels, m.Labels)
+	}
+}
+
 func (s *MemStorage) GetAll() []Metric {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
+
+	result := make([]Metric, 0, len(s.gauges)+len(s.counters)+len(s.histograms))
+	for key, val := range s.gauges {
+		result = append(result, s.gaugeMetric(key, val))
+	}
+	for key, delta := range s.counters {
+		result = append(result, s.counterMetric(key, delta))
+	}
+	for key, h := range s.histograms {
+		result = append(result, s.histogramMetric(key, h))
+	}
+	sortMetrics(result)
+	return result
+}
+
+func (s *MemStorage) Query(sel Selector) []Metric {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	var result []Metric
-	for id, val := range s.gauges {
-		v := val
-		result = append(result, Metric{ID: id, MType: Gauge, Value: &v})
+	if sel.MType == "" || sel.MType == Gauge {
+		for key, val := range s.gauges {
+			if m := s.gaugeMetric(key, val); sel.Matches(m) {
+				result = append(result, m)
+			}
+		}
+	}
+	if sel.MType == "" || sel.MType == Counter {
+		for key, delta := range s.counters {
+			if m := s.counterMetric(key, delta); sel.Matches(m) {
+				result = append(result, m)
+			}
+		}
 	}
-	for id, delta := range s.counters {
-		d := delta
-		result = append(result, Metric{ID: id, MType: Counter, Delta: &d})
+	if sel.MType == "" || sel.MType == Histogram {
+		for key, h := range s.histograms {
+			if m := s.histogramMetric(key, h); sel.Matches(m) {
+				result = append(result, m)
+			}
+		}
 	}
+	sortMetrics(result)
 	return result
 }
 
+// Range returns the recorded points of the series identified by m within
+// [from, to], oldest first.
+func (s *MemStorage) Range(m Metric, from, to time.Time) ([]Point, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r, ok := s.history[historyKey{m.MType, keyOf(m)}]
+	if !ok {
+		return nil, nil
+	}
+	if s.retention.MaxAge > 0 {
+		if oldest := s.now().Add(-s.retention.MaxAge); from.Before(oldest) {
+			from = oldest
+		}
+	}
+	return r.between(from, to), nil
+}
+
 func (s *MemStorage) GetGauge(name string) (float64, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	val, ok := s.gauges[name]
+	val, ok := s.gauges[seriesKey{id: name}]
 	return val, ok
 }
 
 func (s *MemStorage) GetCounter(name string) (int64, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	val, ok := s.counters[name]
+	val, ok := s.counters[seriesKey{id: name}]
 	return val, ok
 }
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStorage_UpdateMetrics(t *testing.T) {
+	s := NewMemStorage()
+	g := 1.5
+	d := int64(2)
+
+	err := s.UpdateMetrics([]Metric{
+		{ID: "g", MType: Gauge, Value: &g},
+		{ID: "c", MType: Counter, Delta: &d},
+		{ID: "c", MType: Counter, Delta: &d},
+	})
+	require.NoError(t, err)
+
+	val, ok := s.GetGauge("g")
+	require.True(t, ok)
+	assert.Equal(t, 1.5, val)
+
+	cnt, ok := s.GetCounter("c")
+	require.True(t, ok)
+	assert.Equal(t, int64(4), cnt)
+}
+
+func TestMemStorage_UpdateMetricsIsAtomic(t *testing.T) {
+	s := NewMemStorage()
+	g := 1.5
+
+	err := s.UpdateMetrics([]Metric{
+		{ID: "g", MType: Gauge, Value: &g},
+		{ID: "c", MType: Counter},
+	})
+	require.ErrorIs(t, err, ErrMissingDelta)
+
+	_, ok := s.GetGauge("g")
+	assert.False(t, ok, "no metric from a rejected batch should be stored")
+	assert.Empty(t, s.GetAll())
+}
+
+func TestMemStorage_Labels(t *testing.T) {
+	s := NewMemStorage()
+	a, b, plain := 1.0, 2.0, 3.0
+	d := int64(1)
+
+	require.NoError(t, s.UpdateMetrics([]Metric{
+		{ID: "Alloc", MType: Gauge, Value: &a, Labels: Labels{"host": "a", "service": "api"}},
+		{ID: "Alloc", MType: Gauge, Value: &b, Labels: Labels{"host": "b", "service": "api"}},
+		{ID: "Alloc", MType: Gauge, Value: &plain},
+		{ID: "PollCount", MType: Counter, Delta: &d, Labels: Labels{"host": "a"}},
+		{ID: "PollCount", MType: Counter, Delta: &d, Labels: Labels{"host": "a"}},
+	}))
+
+	val, ok := s.GetGauge("Alloc")
+	require.True(t, ok, "unlabeled series is kept apart from labeled ones")
+	assert.Equal(t, 3.0, val)
+
+	_, ok = s.GetCounter("PollCount")
+	assert.False(t, ok, "only a labeled PollCount series exists")
+
+	assert.Len(t, s.Query(Selector{ID: "Alloc"}), 3)
+	assert.Len(t, s.Query(Selector{ID: "Alloc", Matchers: Labels{"service": "api"}}), 2)
+
+	hostA := s.Query(Selector{Matchers: Labels{"host": "a"}})
+	require.Len(t, hostA, 2)
+	assert.Equal(t, "Alloc", hostA[0].ID)
+	assert.Equal(t, "PollCount", hostA[1].ID)
+	assert.Equal(t, int64(2), *hostA[1].Delta)
+
+	m, err := FindOne(s, Selector{MType: Gauge, ID: "Alloc", Matchers: Labels{"host": "b"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, *m.Value)
+
+	m, err = FindOne(s, Selector{MType: Gauge, ID: "Alloc"})
+	require.NoError(t, err, "exact match on the empty label set wins")
+	assert.Equal(t, 3.0, *m.Value)
+
+	_, err = FindOne(s, Selector{MType: Gauge, ID: "Alloc", Matchers: Labels{"service": "api"}})
+	assert.ErrorIs(t, err, ErrAmbiguous)
+
+	_, err = FindOne(s, Selector{MType: Gauge, ID: "Missing"})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestMemStorage_SourceAndTenant(t *testing.T) {
+	s := NewMemStorage()
+	a, b, other := 1.0, 2.0, 3.0
+
+	require.NoError(t, s.UpdateMetrics([]Metric{
+		{ID: "Alloc", MType: Gauge, Value: &a, Source: "host-1"},
+		{ID: "Alloc", MType: Gauge, Value: &b, Source: "host-2"},
+		{ID: "Alloc", MType: Gauge, Value: &other, Source: "host-1", Tenant: "team-b"},
+	}))
+
+	assert.Len(t, s.Query(Selector{ID: "Alloc"}), 2, "the default tenant sees only its own series")
+	assert.Len(t, s.Query(Selector{ID: "Alloc", Tenant: "team-b"}), 1)
+	assert.Len(t, s.GetAll(), 3)
+
+	_, err := FindOne(s, Selector{MType: Gauge, ID: "Alloc"})
+	assert.ErrorIs(t, err, ErrAmbiguous)
+
+	m, err := FindOne(s, Selector{MType: Gauge, ID: "Alloc", Source: "host-2"})
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, *m.Value)
+
+	m, err = FindOne(s, Selector{MType: Gauge, ID: "Alloc", Source: "host-1", Tenant: "team-b"})
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, *m.Value)
+	assert.Equal(t, "team-b", m.Tenant)
+}
+
+func TestMemStorage_Histogram(t *testing.T) {
+	s := NewMemStorage()
+
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(2)
+	require.NoError(t, s.UpdateMetric(Metric{ID: "Latency", MType: Histogram, Histogram: h}))
+
+	h.Observe(0.5)
+	require.NoError(t, s.UpdateMetric(Metric{ID: "Latency", MType: Histogram, Histogram: h}))
+
+	h.Observe(0.5)
+	assert.Equal(t, uint64(4), h.Count, "the storage keeps its own copy")
+
+	m, err := FindOne(s, Selector{MType: Histogram, ID: "Latency"})
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{2, 1, 2}, m.Histogram.Counts)
+	assert.Equal(t, uint64(5), m.Histogram.Count)
+	assert.InDelta(t, 4.6, m.Histogram.Sum, 1e-9)
+
+	other := NewHistogram([]float64{1, 2})
+	assert.ErrorIs(t, s.UpdateMetric(Metric{ID: "Latency", MType: Histogram, Histogram: other}), ErrBucketMismatch)
+
+	d := int64(1)
+	err = s.UpdateMetrics([]Metric{
+		{ID: "PollCount", MType: Counter, Delta: &d},
+		{ID: "Latency", MType: Histogram, Histogram: other},
+	})
+	assert.ErrorIs(t, err, ErrBucketMismatch)
+	_, ok := s.GetCounter("PollCount")
+	assert.False(t, ok, "a batch with a mismatched histogram is not applied")
+
+	bad := &HistogramValue{Buckets: []float64{1}, Counts: []uint64{1, 1}, Count: 1}
+	assert.ErrorIs(t, s.UpdateMetric(Metric{ID: "Latency", MType: Histogram, Histogram: bad}), ErrInvalidCounts)
+}
+
+func TestMemStorage_InvalidLabel(t *testing.T) {
+	s := NewMemStorage()
+	v := 1.0
+
+	err := s.UpdateMetric(Metric{ID: "g", MType: Gauge, Value: &v, Labels: Labels{"bad-name": "x"}})
+	assert.ErrorIs(t, err, ErrInvalidLabel)
+	assert.Empty(t, s.GetAll())
+}
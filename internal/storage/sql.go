@@ -1 +1,384 @@
 package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/yokitheyo/guardian-metrics/internal/retry"
+)
+
+const queryTimeout = 3 * time.Second
+
+// migrations are applied in order and recorded in schema_migrations, so a
+// statement never runs twice against the same database.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS metrics (
+		id    TEXT NOT NULL,
+		mtype TEXT NOT NULL,
+		value DOUBLE PRECISION,
+		delta BIGINT,
+		PRIMARY KEY (id, mtype)
+	)`,
+	`ALTER TABLE metrics ADD COLUMN labels TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE metrics DROP CONSTRAINT metrics_pkey`,
+	`ALTER TABLE metrics ADD PRIMARY KEY (id, mtype, labels)`,
+	`ALTER TABLE metrics ADD COLUMN source TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE metrics ADD COLUMN tenant TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE metrics DROP CONSTRAINT metrics_pkey`,
+	`ALTER TABLE metrics ADD PRIMARY KEY (tenant, source, id, mtype, labels)`,
+	`ALTER TABLE metrics ADD COLUMN histogram TEXT`,
+}
+
+const (
+	upsertGaugeQuery = `INSERT INTO metrics (id, mtype, labels, source, tenant, value) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tenant, source, id, mtype, labels) DO UPDATE SET value = EXCLUDED.value`
+	upsertCounterQuery = `INSERT INTO metrics (id, mtype, labels, source, tenant, delta) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (tenant, source, id, mtype, labels) DO UPDATE SET delta = metrics.delta + EXCLUDED.delta`
+	// insertHistogramQuery makes sure the row exists before it is locked, as
+	// FOR UPDATE locks nothing while it is missing. Concurrent inserts of
+	// the same series wait for each other on the primary key.
+	insertHistogramQuery = `INSERT INTO metrics (id, mtype, labels, source, tenant) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (tenant, source, id, mtype, labels) DO NOTHING`
+	updateHistogramQuery = `UPDATE metrics SET histogram = $6
+		WHERE id = $1 AND mtype = $2 AND labels = $3 AND source = $4 AND tenant = $5`
+	// selectHistogramQuery locks the row so concurrent merges into the same
+	// histogram are serialized.
+	selectHistogramQuery = `SELECT histogram FROM metrics
+		WHERE id = $1 AND mtype = $2 AND labels = $3 AND source = $4 AND tenant = $5 FOR UPDATE`
+	// selectMetricsQuery takes the type, id and source filters, where empty
+	// matches anything, then whether to return every tenant and the tenant.
+	selectMetricsQuery = `SELECT id, mtype, labels, source, tenant, value, delta, histogram FROM metrics
+		WHERE ($1 = '' OR mtype = $1) AND ($2 = '' OR id = $2) AND ($3 = '' OR source = $3)
+			AND ($4 OR tenant = $5)
+		ORDER BY id, mtype, tenant, source, labels`
+)
+
+type SQLStorage struct {
+	db          *sql.DB
+	retryDelays []time.Duration
+}
+
+// NewSQLStorage connects to PostgreSQL using the pgx driver and migrates the
+// schema.
+func NewSQLStorage(ctx context.Context, dsn string) (*SQLStorage, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	s, err := NewSQLStorageFromDB(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSQLStorageFromDB wraps an already opened database handle and migrates
+// the schema.
+func NewSQLStorageFromDB(ctx context.Context, db *sql.DB) (*SQLStorage, error) {
+	s := &SQLStorage{db: db, retryDelays: retry.DefaultDelays}
+	err := retry.Do(ctx, s.retryDelays, isRetriableDBError, func() error {
+		return s.Ping(ctx)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	if err := s.migrate(ctx); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return s, nil
+}
+
+func (s *SQLStorage) migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx,
+		`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := s.db.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+
+	for i := current; i < len(migrations); i++ {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+func (s *SQLStorage) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQLStorage) Close() error {
+	return s.db.Close()
+}
+
+// isRetriableDBError reports whether err means the statement never reached
+// the database: a refused or lost connection rather than a problem with
+// the query itself. Timeouts are not retried, as the server may already
+// have committed a write such as a counter increment.
+func isRetriableDBError(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgerrcode.IsConnectionException(pgErr.Code)
+	}
+
+	var connectErr *pgconn.ConnectError
+	return errors.As(err, &connectErr) || errors.Is(err, driver.ErrBadConn)
+}
+
+// withRetry runs fn with a fresh query timeout per attempt and retries it on
+// connection errors.
+func (s *SQLStorage) withRetry(fn func(ctx context.Context) error) error {
+	return retry.Do(context.Background(), s.retryDelays, isRetriableDBError, func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+		defer cancel()
+		return fn(ctx)
+	})
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// upsertHistogram merges m into the stored histogram. It must run in a
+// transaction for the row lock to hold until the update.
+func upsertHistogram(ctx context.Context, e execer, m Metric) error {
+	if err := m.Histogram.Validate(); err != nil {
+		return err
+	}
+	merged := m.Histogram.Clone()
+	args := []any{m.ID, string(m.MType), m.Labels.Key(), m.Source, m.Tenant}
+
+	if _, err := e.ExecContext(ctx, insertHistogramQuery, args...); err != nil {
+		return err
+	}
+	var stored sql.NullString
+	err := e.QueryRowContext(ctx, selectHistogramQuery, args...).Scan(&stored)
+	switch {
+	case err != nil:
+		return err
+	case stored.Valid:
+		var h HistogramValue
+		if err := json.Unmarshal([]byte(stored.String), &h); err != nil {
+			return fmt.Errorf("failed to decode stored histogram: %w", err)
+		}
+		if err := h.Merge(m.Histogram); err != nil {
+			return err
+		}
+		merged = &h
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	_, err = e.ExecContext(ctx, updateHistogramQuery, append(args, string(data))...)
+	return err
+}
+
+func upsert(ctx context.Context, e execer, m Metric) error {
+	if err := m.Labels.Validate(); err != nil {
+		return err
+	}
+	switch m.MType {
+	case Gauge:
+		if m.Value == nil {
+			return ErrMissingValue
+		}
+		_, err := e.ExecContext(ctx, upsertGaugeQuery,
+			m.ID, string(m.MType), m.Labels.Key(), m.Source, m.Tenant, *m.Value)
+		return err
+	case Counter:
+		if m.Delta == nil {
+			return ErrMissingDelta
+		}
+		_, err := e.ExecContext(ctx, upsertCounterQuery,
+			m.ID, string(m.MType), m.Labels.Key(), m.Source, m.Tenant, *m.Delta)
+		return err
+	case Histogram:
+		if m.Histogram == nil {
+			return ErrMissingHistogram
+		}
+		return upsertHistogram(ctx, e, m)
+	default:
+		return ErrInvalidType
+	}
+}
+
+func (s *SQLStorage) UpdateMetric(m Metric) error {
+	if m.MType == Histogram {
+		// Merging reads the stored histogram first, which needs a transaction.
+		return s.UpdateMetrics([]Metric{m})
+	}
+
+	err := s.withRetry(func(ctx context.Context) error {
+		return upsert(ctx, s.db, m)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update metric %q: %w", m.ID, err)
+	}
+	return nil
+}
+
+func (s *SQLStorage) UpdateMetrics(metrics []Metric) error {
+	for _, m := range metrics {
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("metric %q: %w", m.ID, err)
+		}
+	}
+
+	return s.withRetry(func(ctx context.Context) error {
+		tx, err := s.db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+
+		for _, m := range metrics {
+			if err := upsert(ctx, tx, m); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to update metric %q: %w", m.ID, err)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *SQLStorage) GetAll() []Metric {
+	return s.queryWithRetry(Selector{}, true)
+}
+
+// Query filters by type, id, source and tenant in SQL and by label matchers
+// in Go, since labels are stored in their canonical JSON encoding.
+func (s *SQLStorage) Query(sel Selector) []Metric {
+	return s.queryWithRetry(sel, false)
+}
+
+func (s *SQLStorage) queryWithRetry(sel Selector, allTenants bool) []Metric {
+	var result []Metric
+	err := s.withRetry(func(ctx context.Context) error {
+		var err error
+		result, err = s.query(ctx, sel, allTenants)
+		return err
+	})
+	if err != nil {
+		log.Printf("failed to query metrics: %v", err)
+		return nil
+	}
+	return result
+}
+
+func (s *SQLStorage) query(ctx context.Context, sel Selector, allTenants bool) ([]Metric, error) {
+	rows, err := s.db.QueryContext(ctx, selectMetricsQuery,
+		string(sel.MType), sel.ID, sel.Source, allTenants, sel.Tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []Metric
+	for rows.Next() {
+		var (
+			m         Metric
+			labelsKey string
+			value     sql.NullFloat64
+			delta     sql.NullInt64
+			histogram sql.NullString
+		)
+		if err := rows.Scan(&m.ID, &m.MType, &labelsKey, &m.Source, &m.Tenant, &value, &delta, &histogram); err != nil {
+			return nil, err
+		}
+		if m.Labels, err = parseLabelsKey(labelsKey); err != nil {
+			return nil, err
+		}
+		if !m.Labels.Matches(sel.Matchers) {
+			continue
+		}
+		if value.Valid {
+			m.Value = &value.Float64
+		}
+		if delta.Valid {
+			m.Delta = &delta.Int64
+		}
+		if histogram.Valid {
+			m.Histogram = &HistogramValue{}
+			if err := json.Unmarshal([]byte(histogram.String), m.Histogram); err != nil {
+				return nil, fmt.Errorf("failed to decode histogram of %q: %w", m.ID, err)
+			}
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// Range is not supported: the database keeps only the latest value of each
+// series.
+func (s *SQLStorage) Range(Metric, time.Time, time.Time) ([]Point, error) {
+	return nil, ErrHistoryUnsupported
+}
+
+func (s *SQLStorage) GetGauge(name string) (float64, bool) {
+	var val float64
+	err := s.withRetry(func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx,
+			`SELECT value FROM metrics WHERE id = $1 AND mtype = $2 AND labels = '' AND source = '' AND tenant = ''`,
+			name, string(Gauge)).Scan(&val)
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to query gauge %q: %v", name, err)
+		}
+		return 0, false
+	}
+	return val, true
+}
+
+func (s *SQLStorage) GetCounter(name string) (int64, bool) {
+	var val int64
+	err := s.withRetry(func(ctx context.Context) error {
+		return s.db.QueryRowContext(ctx,
+			`SELECT delta FROM metrics WHERE id = $1 AND mtype = $2 AND labels = '' AND source = '' AND tenant = ''`,
+			name, string(Counter)).Scan(&val)
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("failed to query counter %q: %v", name, err)
+		}
+		return 0, false
+	}
+	return val, true
+}
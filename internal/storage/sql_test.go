@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMockSQLStorage(t *testing.T) (*SQLStorage, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	mock.ExpectPing()
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(len(migrations)))
+
+	s, err := NewSQLStorageFromDB(context.Background(), db)
+	require.NoError(t, err)
+	s.retryDelays = []time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}
+	return s, mock
+}
+
+func TestSQLStorage_Migrate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectExec(regexp.QuoteMeta(`CREATE TABLE IF NOT EXISTS schema_migrations`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`)).
+		WillReturnRows(sqlmock.NewRows([]string{"max"}).AddRow(0))
+	for i := range migrations {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(migrations[i])).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO schema_migrations`)).
+			WithArgs(i + 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+	}
+
+	s := &SQLStorage{db: db}
+	require.NoError(t, s.migrate(context.Background()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStorage_UpdateMetric(t *testing.T) {
+	s, mock := newMockSQLStorage(t)
+
+	g := 1.5
+	d := int64(3)
+	mock.ExpectExec(regexp.QuoteMeta(upsertGaugeQuery)).
+		WithArgs("g", "gauge", "", "", "", 1.5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(upsertCounterQuery)).
+		WithArgs("c", "counter", "", "", "", int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, s.UpdateMetric(Metric{ID: "g", MType: Gauge, Value: &g}))
+	require.NoError(t, s.UpdateMetric(Metric{ID: "c", MType: Counter, Delta: &d}))
+	assert.ErrorIs(t, s.UpdateMetric(Metric{ID: "x", MType: "unknown"}), ErrInvalidType)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStorage_UpdateMetricsRollsBack(t *testing.T) {
+	s, mock := newMockSQLStorage(t)
+
+	g := 1.5
+	d := int64(3)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(upsertGaugeQuery)).
+		WithArgs("g", "gauge", "", "", "", 1.5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(upsertCounterQuery)).
+		WithArgs("c", "counter", "", "", "", int64(3)).WillReturnError(errors.New("boom"))
+	mock.ExpectRollback()
+
+	err := s.UpdateMetrics([]Metric{
+		{ID: "g", MType: Gauge, Value: &g},
+		{ID: "c", MType: Counter, Delta: &d},
+	})
+	require.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStorage_Getters(t *testing.T) {
+	s, mock := newMockSQLStorage(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT value FROM metrics`)).
+		WithArgs("g", "gauge").WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(2.5))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT delta FROM metrics`)).
+		WithArgs("missing", "counter").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, mtype, labels, source, tenant, value, delta, histogram FROM metrics`)).
+		WithArgs("", "", "", true, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mtype", "labels", "source", "tenant", "value", "delta", "histogram"}).
+			AddRow("c", "counter", "", "", "", nil, int64(7), nil).
+			AddRow("g", "gauge", "", "", "team-a", 2.5, nil, nil))
+
+	val, ok := s.GetGauge("g")
+	require.True(t, ok)
+	assert.Equal(t, 2.5, val)
+
+	_, ok = s.GetCounter("missing")
+	assert.False(t, ok)
+
+	all := s.GetAll()
+	require.Len(t, all, 2)
+	require.NotNil(t, all[0].Delta)
+	assert.Equal(t, int64(7), *all[0].Delta)
+	require.NotNil(t, all[1].Value)
+	assert.Equal(t, 2.5, *all[1].Value)
+	assert.Equal(t, "team-a", all[1].Tenant)
+
+	_, err := s.Range(all[1], time.Time{}, time.Now())
+	assert.ErrorIs(t, err, ErrHistoryUnsupported)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStorage_RetriesConnectionErrors(t *testing.T) {
+	s, mock := newMockSQLStorage(t)
+
+	g := 1.5
+	mock.ExpectExec(regexp.QuoteMeta(upsertGaugeQuery)).
+		WithArgs("g", "gauge", "", "", "", 1.5).WillReturnError(&pgconn.PgError{Code: pgerrcode.ConnectionFailure})
+	mock.ExpectExec(regexp.QuoteMeta(upsertGaugeQuery)).
+		WithArgs("g", "gauge", "", "", "", 1.5).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, s.UpdateMetric(Metric{ID: "g", MType: Gauge, Value: &g}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.False(t, isRetriableDBError(&pgconn.PgError{Code: pgerrcode.UniqueViolation}), "only connection exceptions are retried")
+	assert.True(t, isRetriableDBError(driver.ErrBadConn))
+}
+
+func TestSQLStorage_DoesNotRetryTimeouts(t *testing.T) {
+	s, mock := newMockSQLStorage(t)
+
+	mock.ExpectExec(regexp.QuoteMeta(upsertCounterQuery)).
+		WithArgs("c", "counter", "", "", "", int64(1)).WillReturnError(context.DeadlineExceeded)
+
+	d := int64(1)
+	require.Error(t, s.UpdateMetric(Metric{ID: "c", MType: Counter, Delta: &d}))
+	assert.NoError(t, mock.ExpectationsWereMet(), "a write that timed out may have been applied and must not be repeated")
+}
+
+func TestSQLStorage_DoesNotRetryQueryErrors(t *testing.T) {
+	s, mock := newMockSQLStorage(t)
+
+	g := 1.5
+	mock.ExpectExec(regexp.QuoteMeta(upsertGaugeQuery)).
+		WithArgs("g", "gauge", "", "", "", 1.5).WillReturnError(&pgconn.PgError{Code: pgerrcode.UndefinedTable})
+
+	require.Error(t, s.UpdateMetric(Metric{ID: "g", MType: Gauge, Value: &g}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStorage_Labels(t *testing.T) {
+	s, mock := newMockSQLStorage(t)
+
+	g := 1.5
+	mock.ExpectExec(regexp.QuoteMeta(upsertGaugeQuery)).
+		WithArgs("g", "gauge", `{"host":"a","service":"api"}`, "", "", 1.5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, mtype, labels, source, tenant, value, delta, histogram FROM metrics`)).
+		WithArgs("gauge", "g", "", false, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mtype", "labels", "source", "tenant", "value", "delta", "histogram"}).
+			AddRow("g", "gauge", `{"host":"a","service":"api"}`, "", "", 1.5, nil, nil).
+			AddRow("g", "gauge", `{"host":"b","service":"api"}`, "", "", 2.5, nil, nil))
+
+	require.NoError(t, s.UpdateMetric(Metric{
+		ID: "g", MType: Gauge, Value: &g,
+		Labels: Labels{"service": "api", "host": "a"},
+	}))
+
+	matched := s.Query(Selector{MType: Gauge, ID: "g", Matchers: Labels{"host": "b"}})
+	require.Len(t, matched, 1)
+	assert.Equal(t, Labels{"host": "b", "service": "api"}, matched[0].Labels)
+	assert.Equal(t, 2.5, *matched[0].Value)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStorage_SourceAndTenant(t *testing.T) {
+	s, mock := newMockSQLStorage(t)
+
+	g := 1.5
+	mock.ExpectExec(regexp.QuoteMeta(upsertGaugeQuery)).
+		WithArgs("Alloc", "gauge", "", "host-1", "team-a", 1.5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, mtype, labels, source, tenant, value, delta, histogram FROM metrics`)).
+		WithArgs("gauge", "Alloc", "host-1", false, "team-a").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mtype", "labels", "source", "tenant", "value", "delta", "histogram"}).
+			AddRow("Alloc", "gauge", "", "host-1", "team-a", 1.5, nil, nil))
+
+	require.NoError(t, s.UpdateMetric(Metric{ID: "Alloc", MType: Gauge, Value: &g, Source: "host-1", Tenant: "team-a"}))
+
+	matched := s.Query(Selector{MType: Gauge, ID: "Alloc", Source: "host-1", Tenant: "team-a"})
+	require.Len(t, matched, 1)
+	assert.Equal(t, "host-1", matched[0].Source)
+	assert.Equal(t, "team-a", matched[0].Tenant)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStorage_Histogram(t *testing.T) {
+	s, mock := newMockSQLStorage(t)
+
+	h := NewHistogram([]float64{0.1, 1})
+	h.Observe(0.05)
+	h.Observe(0.5)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(insertHistogramQuery)).
+		WithArgs("Latency", "histogram", "", "", "").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(selectHistogramQuery)).
+		WithArgs("Latency", "histogram", "", "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"histogram"}).
+			AddRow(`{"buckets":[0.1,1],"counts":[0,0,1],"count":1,"sum":3}`))
+	mock.ExpectExec(regexp.QuoteMeta(updateHistogramQuery)).
+		WithArgs("Latency", "histogram", "", "", "", `{"buckets":[0.1,1],"counts":[1,1,1],"count":3,"sum":3.55}`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id, mtype, labels, source, tenant, value, delta, histogram FROM metrics`)).
+		WithArgs("histogram", "Latency", "", false, "").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "mtype", "labels", "source", "tenant", "value", "delta", "histogram"}).
+			AddRow("Latency", "histogram", "", "", "", nil, nil, `{"buckets":[0.1,1],"counts":[1,1,1],"count":3,"sum":3.55}`))
+
+	require.NoError(t, s.UpdateMetric(Metric{ID: "Latency", MType: Histogram, Histogram: h}))
+
+	matched := s.Query(Selector{MType: Histogram, ID: "Latency"})
+	require.Len(t, matched, 1)
+	require.NotNil(t, matched[0].Histogram)
+	assert.Equal(t, uint64(3), matched[0].Histogram.Count)
+	assert.Equal(t, []uint64{1, 1, 1}, matched[0].Histogram.Counts)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSQLStorage_HistogramBucketMismatch(t *testing.T) {
+	s, mock := newMockSQLStorage(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(insertHistogramQuery)).
+		WithArgs("Latency", "histogram", "", "", "").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(selectHistogramQuery)).
+		WithArgs("Latency", "histogram", "", "", "").
+		WillReturnRows(sqlmock.NewRows([]string{"histogram"}).
+			AddRow(`{"buckets":[0.1,1],"counts":[0,0,1],"count":1,"sum":3}`))
+	mock.ExpectRollback()
+
+	err := s.UpdateMetric(Metric{ID: "Latency", MType: Histogram, Histogram: NewHistogram([]float64{5})})
+	assert.ErrorIs(t, err, ErrBucketMismatch)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestSQLStorage_Postgres runs against a real database when
+// TEST_DATABASE_DSN points to one, e.g. a local docker postgres.
+func TestSQLStorage_Postgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	ctx := context.Background()
+	s, err := NewSQLStorage(ctx, dsn)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, err = s.db.ExecContext(ctx, `DELETE FROM metrics`)
+	require.NoError(t, err)
+
+	g := 1.5
+	d := int64(2)
+	require.NoError(t, s.UpdateMetrics([]Metric{
+		{ID: "g", MType: Gauge, Value: &g},
+		{ID: "c", MType: Counter, Delta: &d},
+		{ID: "c", MType: Counter, Delta: &d},
+	}))
+
+	val, ok := s.GetGauge("g")
+	require.True(t, ok)
+	assert.Equal(t, 1.5, val)
+
+	cnt, ok := s.GetCounter("c")
+	require.True(t, ok)
+	assert.Equal(t, int64(4), cnt)
+
+	require.NoError(t, s.UpdateMetric(Metric{ID: "g", MType: Gauge, Value: &g, Labels: Labels{"host": "a"}}))
+	assert.Len(t, s.Query(Selector{ID: "g"}), 2)
+	assert.Len(t, s.Query(Selector{ID: "g", Matchers: Labels{"host": "a"}}), 1)
+
+	require.NoError(t, s.UpdateMetric(Metric{ID: "g", MType: Gauge, Value: &g, Source: "host-1", Tenant: "team-a"}))
+	assert.Len(t, s.Query(Selector{ID: "g"}), 2, "other tenants are not visible")
+	assert.Len(t, s.Query(Selector{ID: "g", Tenant: "team-a"}), 1)
+	assert.Len(t, s.GetAll(), 4)
+
+	assert.NoError(t, s.Ping(ctx))
+}
@@ -1,22 +1,99 @@
 package storage
 
+import (
+	"context"
+	"errors"
+	"time"
+)
+
 type MetricType string
 
 const (
-	Gauge   MetricType = "gauge"
-	Counter MetricType = "counter"
+	Gauge     MetricType = "gauge"
+	Counter   MetricType = "counter"
+	Histogram MetricType = "histogram"
+)
+
+var (
+	ErrEmptyID      = errors.New("metric id is empty")
+	ErrInvalidType  = errors.New("invalid metric type")
+	ErrMissingValue = errors.New("gauge value is missing")
+	ErrMissingDelta = errors.New("counter delta is missing")
 )
 
 type Metric struct {
-	ID    string
-	MType MetricType
-	Value *float64
-	Delta *int64
+	ID     string     `json:"id"`
+	MType  MetricType `json:"type"`
+	Delta  *int64     `json:"delta,omitempty"`
+	Value  *float64   `json:"value,omitempty"`
+	Labels Labels     `json:"labels,omitempty"`
+	// Histogram holds the observations of a histogram metric; updates are
+	// merged into the stored histogram.
+	Histogram *HistogramValue `json:"histogram,omitempty"`
+	// Source is the agent that reported the metric and Tenant the namespace
+	// it belongs to. Both are part of the series identity.
+	Source string `json:"source,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// NewGauge returns a gauge metric holding v.
+func NewGauge(id string, v float64) Metric {
+	return Metric{ID: id, MType: Gauge, Value: &v}
+}
+
+// NewCounter returns a counter metric adding delta.
+func NewCounter(id string, delta int64) Metric {
+	return Metric{ID: id, MType: Counter, Delta: &delta}
+}
+
+// Validate checks that the metric has a name, a known type, the field
+// matching that type and well-formed label names.
+func (m Metric) Validate() error {
+	if m.ID == "" {
+		return ErrEmptyID
+	}
+	if err := m.Labels.Validate(); err != nil {
+		return err
+	}
+	switch m.MType {
+	case Gauge:
+		if m.Value == nil {
+			return ErrMissingValue
+		}
+	case Counter:
+		if m.Delta == nil {
+			return ErrMissingDelta
+		}
+	case Histogram:
+		if m.Histogram == nil {
+			return ErrMissingHistogram
+		}
+		return m.Histogram.Validate()
+	default:
+		return ErrInvalidType
+	}
+	return nil
 }
 
 type Storage interface {
 	UpdateMetric(m Metric) error
+	// UpdateMetrics applies the whole batch or nothing at all.
+	UpdateMetrics(metrics []Metric) error
+	// GetAll returns every series of every tenant and Query the series
+	// matched by sel. Both are sorted by id, type, tenant, source and labels.
 	GetAll() []Metric
+	Query(sel Selector) []Metric
+	// Range returns the recorded points of the series identified by the id,
+	// type, labels, source and tenant of m within [from, to], oldest first.
+	// Histograms keep no history.
+	Range(m Metric, from, to time.Time) ([]Point, error)
+	// GetGauge and GetCounter look up the series without labels, source
+	// and tenant.
 	GetGauge(name string) (float64, bool)
 	GetCounter(name string) (int64, bool)
 }
+
+// Pinger is implemented by storages backed by an external database.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
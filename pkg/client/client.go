@@ -0,0 +1,252 @@
+// Package client lets Go services push their own metrics to a
+// guardian-metrics server. Metrics are aggregated in process and flushed
+// in batches by a background goroutine:
+//
+//	c, err := client.New(client.Config{Address: "localhost:8080"})
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close(context.Background())
+//
+//	c.Counter("requests").Add(1)
+//	c.Gauge("queue_length").Set(float64(len(queue)))
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yokitheyo/guardian-metrics/internal/agent/sender"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"github.com/yokitheyo/guardian-metrics/internal/retry"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+const DefaultFlushInterval = 10 * time.Second
+
+var errEmptyName = errors.New("client: metric name is empty")
+
+// DefaultBuckets are the histogram buckets used when none are given; they
+// suit latencies measured in seconds and match the server defaults.
+var DefaultBuckets = slices.Clone(storage.DefaultBuckets)
+
+type Config struct {
+	// Address of the server, e.g. localhost:8080 or https://metrics.local.
+	Address string
+	// Key signs request bodies with HMAC-SHA256 when set.
+	Key string
+	// Labels are attached to every metric.
+	Labels map[string]string
+	// Source and Tenant identify the service to the server.
+	Source string
+	Tenant string
+	// FlushInterval defaults to DefaultFlushInterval.
+	FlushInterval time.Duration
+	// OnError is called when a background flush fails or a metric is
+	// requested with an empty name or invalid buckets, by default the error
+	// is logged. Values the server could not be reached with are kept for
+	// the next flush.
+	OnError func(error)
+}
+
+// Client collects metrics and sends them periodically. It is safe for
+// concurrent use.
+type Client struct {
+	sender   *sender.HTTPSender
+	labels   storage.Labels
+	interval time.Duration
+	onError  func(error)
+
+	mu         sync.Mutex
+	gauges     map[string]*Gauge
+	counters   map[string]*Counter
+	histograms map[string]*Histogram
+
+	// flushMu keeps flushes from interleaving, so values put back after a
+	// failed send are not overtaken by newer ones.
+	flushMu   sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func New(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, errors.New("client: address is empty")
+	}
+	labels := storage.Labels(cfg.Labels).Clone()
+	if err := labels.Validate(); err != nil {
+		return nil, err
+	}
+
+	address := cfg.Address
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+
+	c := &Client{
+		sender:     sender.NewHTTPSender(address, cfg.Key, nil, identity.Identity{Source: cfg.Source, Tenant: cfg.Tenant}),
+		labels:     labels,
+		interval:   cfg.FlushInterval,
+		onError:    cfg.OnError,
+		gauges:     make(map[string]*Gauge),
+		counters:   make(map[string]*Counter),
+		histograms: make(map[string]*Histogram),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if c.interval <= 0 {
+		c.interval = DefaultFlushInterval
+	}
+	if c.onError == nil {
+		c.onError = func(err error) { log.Printf("guardian-metrics client: %v", err) }
+	}
+
+	go c.flushLoop()
+	return c, nil
+}
+
+// Gauge returns the gauge called name, creating it on first use. An empty
+// name is reported to OnError and yields a gauge that is never sent.
+func (c *Client) Gauge(name string) *Gauge {
+	if name == "" {
+		c.onError(errEmptyName)
+		return &Gauge{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	g, ok := c.gauges[name]
+	if !ok {
+		g = &Gauge{}
+		c.gauges[name] = g
+	}
+	return g
+}
+
+// Counter returns the counter called name, creating it on first use. An
+// empty name is reported to OnError and yields a counter that is never
+// sent.
+func (c *Client) Counter(name string) *Counter {
+	if name == "" {
+		c.onError(errEmptyName)
+		return &Counter{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cnt, ok := c.counters[name]
+	if !ok {
+		cnt = &Counter{}
+		c.counters[name] = cnt
+	}
+	return cnt
+}
+
+// Histogram returns the histogram called name, creating it with buckets on
+// first use. Nil buckets mean DefaultBuckets. An empty name or buckets that
+// are not finite and strictly increasing are reported to OnError and yield
+// a histogram that is never sent.
+func (c *Client) Histogram(name string, buckets []float64) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	detached := func(err error) *Histogram {
+		c.onError(err)
+		return &Histogram{buckets: DefaultBuckets, pending: storage.NewHistogram(DefaultBuckets)}
+	}
+	if name == "" {
+		return detached(errEmptyName)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h, ok := c.histograms[name]
+	if !ok {
+		pending := storage.NewHistogram(buckets)
+		if err := pending.Validate(); err != nil {
+			return detached(fmt.Errorf("client: histogram %q: %w", name, err))
+		}
+		h = &Histogram{buckets: pending.Buckets, pending: pending}
+		c.histograms[name] = h
+	}
+	return h
+}
+
+// Flush sends everything recorded since the last successful flush. When the
+// server cannot be reached the values are kept and sent with the next
+// flush; values the server rejects are dropped so they cannot block the
+// ones that follow.
+func (c *Client) Flush(ctx context.Context) error {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	var (
+		batch   []storage.Metric
+		restore []func()
+	)
+	c.mu.Lock()
+	for name, g := range c.gauges {
+		if v, ok := g.take(); ok {
+			batch = append(batch, storage.Metric{ID: name, MType: storage.Gauge, Value: &v, Labels: c.labels})
+			restore = append(restore, g.markUnsent)
+		}
+	}
+	for name, cnt := range c.counters {
+		if d := cnt.take(); d != 0 {
+			batch = append(batch, storage.Metric{ID: name, MType: storage.Counter, Delta: &d, Labels: c.labels})
+			restore = append(restore, func() { cnt.Add(d) })
+		}
+	}
+	for name, h := range c.histograms {
+		if taken := h.take(); taken != nil {
+			batch = append(batch, storage.Metric{ID: name, MType: storage.Histogram, Histogram: taken, Labels: c.labels})
+			restore = append(restore, func() { h.restore(taken) })
+		}
+	}
+	c.mu.Unlock()
+
+	if err := c.sender.SendBatch(ctx, batch); err != nil {
+		if retry.IsRetriable(err) {
+			for _, fn := range restore {
+				fn()
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Client) flushLoop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.Flush(context.Background()); err != nil {
+				c.onError(err)
+			}
+		}
+	}
+}
+
+// Close stops the background flusher and flushes what is left, giving up
+// when ctx is done.
+func (c *Client) Close(ctx context.Context) error {
+	c.closeOnce.Do(func() { close(c.stop) })
+	<-c.done
+	return c.Flush(ctx)
+}
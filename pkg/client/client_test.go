@@ -0,0 +1,239 @@
+package client
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/yokitheyo/guardian-metrics/internal/identity"
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+// fakeServer records the batches posted to /updates/. It fails with 503
+// while fail is set and rejects batches with 400 while reject is set.
+type fakeServer struct {
+	mu      sync.Mutex
+	batches [][]storage.Metric
+	headers []http.Header
+	fail    atomic.Bool
+	reject  atomic.Bool
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.fail.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if s.reject.Load() {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	zr, err := gzip.NewReader(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var batch []storage.Metric
+	if err := json.NewDecoder(zr).Decode(&batch); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sort.Slice(batch, func(i, j int) bool { return batch[i].ID < batch[j].ID })
+
+	s.mu.Lock()
+	s.batches = append(s.batches, batch)
+	s.headers = append(s.headers, r.Header.Clone())
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *fakeServer) last() []storage.Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.batches) == 0 {
+		return nil
+	}
+	return s.batches[len(s.batches)-1]
+}
+
+func (s *fakeServer) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches)
+}
+
+func newTestClient(t *testing.T, cfg Config) (*Client, *fakeServer) {
+	t.Helper()
+	srv := &fakeServer{}
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+
+	cfg.Address = ts.URL
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = time.Hour
+	}
+	c, err := New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { c.Close(context.Background()) })
+	return c, srv
+}
+
+func TestClient_Flush(t *testing.T) {
+	c, srv := newTestClient(t, Config{
+		Labels: map[string]string{"service": "billing"},
+		Source: "billing-1",
+		Tenant: "team-a",
+	})
+	ctx := context.Background()
+
+	c.Gauge("queue_length").Set(3)
+	c.Gauge("queue_length").Set(5)
+	c.Counter("requests").Add(2)
+	c.Counter("requests").Inc()
+	c.Histogram("latency", []float64{0.1, 1}).Observe(0.5)
+
+	require.NoError(t, c.Flush(ctx))
+	batch := srv.last()
+	require.Len(t, batch, 3)
+
+	assert.Equal(t, "latency", batch[0].ID)
+	assert.Equal(t, storage.Histogram, batch[0].MType)
+	assert.Equal(t, []uint64{0, 1, 0}, batch[0].Histogram.Counts)
+
+	assert.Equal(t, "queue_length", batch[1].ID)
+	assert.Equal(t, 5.0, *batch[1].Value)
+
+	assert.Equal(t, "requests", batch[2].ID)
+	assert.Equal(t, int64(3), *batch[2].Delta)
+	assert.Equal(t, storage.Labels{"service": "billing"}, batch[2].Labels)
+
+	assert.Equal(t, "billing-1", srv.headers[0].Get(identity.AgentHeader))
+	assert.Equal(t, "team-a", srv.headers[0].Get(identity.TenantHeader))
+
+	require.NoError(t, c.Flush(ctx))
+	assert.Equal(t, 1, srv.count(), "nothing changed, nothing is sent")
+
+	c.Counter("requests").Add(4)
+	require.NoError(t, c.Flush(ctx))
+	batch = srv.last()
+	require.Len(t, batch, 1)
+	assert.Equal(t, int64(4), *batch[0].Delta, "counters send the increase since the last flush")
+}
+
+func TestClient_KeepsValuesOnFailure(t *testing.T) {
+	c, srv := newTestClient(t, Config{})
+	ctx := context.Background()
+
+	c.Counter("requests").Add(2)
+	c.Gauge("queue_length").Set(1)
+	c.Histogram("latency", nil).Observe(0.2)
+
+	srv.fail.Store(true)
+	failCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	require.Error(t, c.Flush(failCtx))
+
+	c.Counter("requests").Add(3)
+	srv.fail.Store(false)
+	require.NoError(t, c.Flush(ctx))
+
+	batch := srv.last()
+	require.Len(t, batch, 3)
+	assert.Equal(t, uint64(1), batch[0].Histogram.Count)
+	assert.Equal(t, DefaultBuckets, batch[0].Histogram.Buckets)
+	assert.Equal(t, 1.0, *batch[1].Value)
+	assert.Equal(t, int64(5), *batch[2].Delta)
+}
+
+func TestClient_DropsRejectedValues(t *testing.T) {
+	c, srv := newTestClient(t, Config{})
+	ctx := context.Background()
+
+	c.Counter("requests").Add(2)
+	c.Gauge("queue_length").Set(1)
+
+	srv.reject.Store(true)
+	require.Error(t, c.Flush(ctx))
+
+	srv.reject.Store(false)
+	require.NoError(t, c.Flush(ctx))
+	assert.Equal(t, 0, srv.count(), "rejected values are not sent again")
+
+	c.Counter("requests").Add(3)
+	require.NoError(t, c.Flush(ctx))
+	batch := srv.last()
+	require.Len(t, batch, 1)
+	assert.Equal(t, int64(3), *batch[0].Delta)
+}
+
+func TestClient_IgnoresInvalidValues(t *testing.T) {
+	var errs []error
+	c, srv := newTestClient(t, Config{OnError: func(err error) { errs = append(errs, err) }})
+	ctx := context.Background()
+
+	buckets := []float64{1, 2}
+	c.Gauge("queue_length").Set(math.NaN())
+	c.Gauge("").Set(1)
+	c.Counter("").Inc()
+	c.Histogram("", nil).Observe(1)
+	c.Histogram("unordered", []float64{2, 1}).Observe(1)
+	c.Histogram("latency", buckets).Observe(math.Inf(1))
+	c.Histogram("latency", buckets).Observe(math.MaxFloat64)
+	c.Histogram("latency", buckets).Observe(math.MaxFloat64)
+	buckets[0] = 5
+	c.Histogram("latency", nil).Observe(1.5)
+	c.Counter("requests").Inc()
+
+	require.NoError(t, c.Flush(ctx))
+	assert.Len(t, errs, 4)
+	for _, err := range errs[:3] {
+		assert.ErrorIs(t, err, errEmptyName)
+	}
+	assert.ErrorIs(t, errs[3], storage.ErrInvalidBuckets)
+
+	batch := srv.last()
+	require.Len(t, batch, 2)
+	assert.Equal(t, "latency", batch[0].ID)
+	assert.Equal(t, []float64{1, 2}, batch[0].Histogram.Buckets, "buckets are copied")
+	assert.Equal(t, []uint64{0, 1, 1}, batch[0].Histogram.Counts)
+	assert.Equal(t, "requests", batch[1].ID)
+}
+
+func TestClient_BackgroundFlushAndClose(t *testing.T) {
+	c, srv := newTestClient(t, Config{FlushInterval: 10 * time.Millisecond})
+
+	c.Counter("requests").Inc()
+	require.Eventually(t, func() bool { return srv.count() > 0 }, time.Second, 5*time.Millisecond)
+
+	c.Counter("requests").Add(7)
+	require.NoError(t, c.Close(context.Background()))
+	require.NoError(t, c.Close(context.Background()), "closing twice is fine")
+
+	var total int64
+	srv.mu.Lock()
+	for _, batch := range srv.batches {
+		for _, m := range batch {
+			total += *m.Delta
+		}
+	}
+	srv.mu.Unlock()
+	assert.Equal(t, int64(8), total, "close flushes what is left")
+}
+
+func TestNew_Invalid(t *testing.T) {
+	_, err := New(Config{})
+	assert.Error(t, err)
+
+	_, err = New(Config{Address: "localhost:8080", Labels: map[string]string{"bad-name": "x"}})
+	assert.ErrorIs(t, err, storage.ErrInvalidLabel)
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/yokitheyo/guardian-metrics/internal/storage"
+)
+
+// Gauge holds the latest value set. It is sent only when set since the
+// last flush.
+type Gauge struct {
+	bits  atomic.Uint64
+	dirty atomic.Bool
+}
+
+// Set records v. NaN and infinite values are ignored.
+func (g *Gauge) Set(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	g.bits.Store(math.Float64bits(v))
+	g.dirty.Store(true)
+}
+
+func (g *Gauge) take() (float64, bool) {
+	if !g.dirty.Swap(false) {
+		return 0, false
+	}
+	return math.Float64frombits(g.bits.Load()), true
+}
+
+// markUnsent makes the next flush send the gauge again, with whatever value
+// it holds by then.
+func (g *Gauge) markUnsent() {
+	g.dirty.Store(true)
+}
+
+// Counter accumulates increments until they are flushed.
+type Counter struct {
+	pending atomic.Int64
+}
+
+func (c *Counter) Add(n int64) {
+	c.pending.Add(n)
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+func (c *Counter) take() int64 {
+	return c.pending.Swap(0)
+}
+
+// Histogram accumulates observations until they are flushed.
+type Histogram struct {
+	buckets []float64
+
+	mu      sync.Mutex
+	pending *storage.HistogramValue
+}
+
+// Observe records v. NaN and infinite values, and values that would make
+// the sum overflow, are ignored.
+func (h *Histogram) Observe(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if math.IsInf(h.pending.Sum+v, 0) {
+		return
+	}
+	h.pending.Observe(v)
+}
+
+func (h *Histogram) take() *storage.HistogramValue {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.pending.Count == 0 {
+		return nil
+	}
+	taken := h.pending
+	h.pending = storage.NewHistogram(h.buckets)
+	return taken
+}
+
+func (h *Histogram) restore(taken *storage.HistogramValue) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// Both share the buckets the histogram was created with.
+	_ = h.pending.Merge(taken)
+}